@@ -0,0 +1,252 @@
+package capture
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HAR 1.2 types, see http://www.softwareishard.com/blog/har-12-spec/
+
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Expires  string `json:"expires,omitempty"`
+	HTTPOnly bool   `json:"httpOnly,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type HARContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// ToHAR converts capture entries into a HAR 1.2 log
+func ToHAR(entries []Entry, creatorVersion string) HAR {
+	har := HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "devpipe", Version: creatorVersion},
+		Entries: make([]HAREntry, 0, len(entries)),
+	}}
+	for _, entry := range entries {
+		har.Log.Entries = append(har.Log.Entries, toHAREntry(entry))
+	}
+	return har
+}
+
+// WriteHAR writes entries to w as an indented HAR 1.2 document
+func WriteHAR(w io.Writer, entries []Entry, creatorVersion string) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(ToHAR(entries, creatorVersion))
+}
+
+func toHAREntry(entry Entry) HAREntry {
+	req, resp := entry.Request, entry.Response
+	reqHeader, respHeader := canonical(req.Headers), canonical(resp.Headers)
+
+	harEntry := HAREntry{
+		StartedDateTime: entry.Time.Format(time.RFC3339Nano),
+		Time:            entry.LatencyMs,
+		Request: HARRequest{
+			Method:      req.Method,
+			URL:         requestURL(req, reqHeader),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     requestCookies(reqHeader),
+			Headers:     nameValues(req.Headers),
+			QueryString: queryString(req.Path),
+			HeadersSize: -1,
+			BodySize:    req.BodySize,
+		},
+		Response: HARResponse{
+			Status:      resp.Status,
+			StatusText:  http.StatusText(resp.Status),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     responseCookies(respHeader),
+			Headers:     nameValues(resp.Headers),
+			Content: HARContent{
+				Size:     resp.BodySize,
+				MimeType: respHeader.Get("Content-Type"),
+				Text:     resp.Body,
+				Encoding: resp.BodyEncoding,
+			},
+			RedirectURL: respHeader.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    resp.BodySize,
+		},
+		Timings: HARTimings{Send: 0, Wait: entry.LatencyMs, Receive: 0},
+		Comment: resp.Error,
+	}
+
+	if req.BodySize > 0 {
+		postData := &HARPostData{MimeType: reqHeader.Get("Content-Type"), Text: req.Body}
+		if req.BodyEncoding != "" {
+			postData.Comment = "text is " + req.BodyEncoding + "-encoded"
+		}
+		if req.Truncated {
+			postData.Comment = strings.TrimPrefix(postData.Comment+"; truncated", "; ")
+		}
+		harEntry.Request.PostData = postData
+	}
+	if resp.Truncated {
+		harEntry.Response.Content.Comment = "truncated"
+	}
+	return harEntry
+}
+
+// requestURL rebuilds the public URL from the Host header, falling back to the local target
+func requestURL(req Request, header http.Header) string {
+	if host := header.Get("Host"); host != "" {
+		return "https://" + host + req.Path
+	}
+	return strings.TrimSuffix(req.Target, "/") + req.Path
+}
+
+// canonical copies headers with canonical keys, as servers may send them in any case
+func canonical(headers map[string][]string) http.Header {
+	header := make(http.Header, len(headers))
+	for k, values := range headers {
+		for _, v := range values {
+			header.Add(k, v)
+		}
+	}
+	return header
+}
+
+func nameValues(headers map[string][]string) []HARNameValue {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	values := make([]HARNameValue, 0, len(headers))
+	for _, k := range keys {
+		for _, v := range headers[k] {
+			values = append(values, HARNameValue{Name: k, Value: v})
+		}
+	}
+	return values
+}
+
+func queryString(path string) []HARNameValue {
+	values := []HARNameValue{}
+	parsed, err := url.Parse(path)
+	if err != nil {
+		return values
+	}
+	query := parsed.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range query[k] {
+			values = append(values, HARNameValue{Name: k, Value: v})
+		}
+	}
+	return values
+}
+
+func requestCookies(header http.Header) []HARCookie {
+	cookies := []HARCookie{}
+	for _, c := range (&http.Request{Header: header}).Cookies() {
+		cookies = append(cookies, HARCookie{Name: c.Name, Value: c.Value})
+	}
+	return cookies
+}
+
+func responseCookies(header http.Header) []HARCookie {
+	cookies := []HARCookie{}
+	for _, c := range (&http.Response{Header: header}).Cookies() {
+		cookie := HARCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HTTPOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		}
+		if !c.Expires.IsZero() {
+			cookie.Expires = c.Expires.Format(time.RFC3339)
+		}
+		cookies = append(cookies, cookie)
+	}
+	return cookies
+}
@@ -0,0 +1,149 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/panngo/devpipe-cli/inspector"
+)
+
+// Request is the captured request side of an entry
+type Request struct {
+	ID           string              `json:"id"`
+	Tunnel       string              `json:"tunnel,omitempty"`
+	Method       string              `json:"method"`
+	Path         string              `json:"path"`
+	Target       string              `json:"target,omitempty"`
+	Webhook      string              `json:"webhook,omitempty"`
+	Headers      map[string][]string `json:"headers"`
+	Body         string              `json:"body"`
+	BodyEncoding string              `json:"body_encoding,omitempty"`
+	BodySize     int64               `json:"body_size"`
+	Truncated    bool                `json:"truncated,omitempty"`
+}
+
+// Response is the captured response side of an entry
+type Response struct {
+	Status       int                 `json:"status"`
+	Headers      map[string][]string `json:"headers"`
+	Body         string              `json:"body"`
+	BodyEncoding string              `json:"body_encoding,omitempty"`
+	BodySize     int64               `json:"body_size"`
+	Truncated    bool                `json:"truncated,omitempty"`
+	Error        string              `json:"error,omitempty"`
+}
+
+// Entry is one line of a capture file: a request, its response and timing
+type Entry struct {
+	Time      time.Time `json:"time"`
+	LatencyMs float64   `json:"latency_ms"`
+	Request   Request   `json:"request"`
+	Response  Response  `json:"response"`
+}
+
+// FromExchange converts a finished inspector exchange into a capture entry
+func FromExchange(ex inspector.Exchange) Entry {
+	entry := Entry{
+		Time:      ex.Started,
+		LatencyMs: float64(ex.Duration.Microseconds()) / 1000,
+		Request: Request{
+			ID:        ex.ID,
+			Tunnel:    ex.Tunnel,
+			Method:    ex.Method,
+			Path:      ex.Path,
+			Target:    ex.Target,
+			Webhook:   ex.Webhook,
+			Headers:   ex.RequestHeaders,
+			BodySize:  ex.RequestSize,
+			Truncated: ex.RequestTruncated,
+		},
+		Response: Response{
+			Status:    ex.Status,
+			Headers:   ex.ResponseHeaders,
+			BodySize:  ex.ResponseSize,
+			Truncated: ex.ResponseTruncated,
+			Error:     ex.Error,
+		},
+	}
+	entry.Request.Body, entry.Request.BodyEncoding = encodeBody(ex.RequestBody)
+	entry.Response.Body, entry.Response.BodyEncoding = encodeBody(ex.ResponseBody)
+	return entry
+}
+
+// Recorder appends entries to a JSONL capture file
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewRecorder opens a capture file for appending, creating it if needed
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture file: %w", err)
+	}
+	return &Recorder{file: file}, nil
+}
+
+// Write appends one entry as a single line
+func (r *Recorder) Write(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal capture entry: %w", err)
+	}
+	data = append(data, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write capture entry: %w", err)
+	}
+	return nil
+}
+
+// Close closes the capture file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// ReadFile loads every entry of a capture file
+func ReadFile(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture file: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*inspector.BodyLimit)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid capture entry on line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read capture file: %w", err)
+	}
+	return entries, nil
+}
+
+func encodeBody(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
@@ -0,0 +1,211 @@
+package client
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// authTokenName is the query parameter and cookie that carry the -auth-token
+const authTokenName = "devpipe_token"
+
+// Auth gates a tunnel behind basic auth and/or a token. A request passes if
+// it satisfies either one
+type Auth struct {
+	User     string
+	Password string
+	Token    string
+}
+
+// newAuth parses the -basic-auth (user:pass) and -auth-token settings.
+// It returns nil when neither is set
+func newAuth(basic, token string) (*Auth, error) {
+	if basic == "" && token == "" {
+		return nil, nil
+	}
+	auth := &Auth{Token: token}
+	if basic != "" {
+		user, password, ok := strings.Cut(basic, ":")
+		if !ok || user == "" {
+			return nil, errors.New("invalid basic auth, expected user:pass")
+		}
+		auth.User, auth.Password = user, password
+	}
+	return auth, nil
+}
+
+// authResult is the outcome of checking a request against the gate
+type authResult struct {
+	ok bool
+	// path and headers are what to forward, without the gate's own credentials
+	path    string
+	headers Header
+	// setCookie is set when the token came in the query, so the browser keeps it
+	setCookie bool
+}
+
+// check looks for valid credentials in the Authorization header, the
+// devpipe_token cookie or the devpipe_token query parameter
+func (a *Auth) check(path string, headers Header) authResult {
+	if a == nil {
+		return authResult{ok: true, path: path, headers: headers}
+	}
+	result := authResult{path: path, headers: headers.clone()}
+
+	authorization := headers.Get("Authorization")
+	if scheme, credentials, ok := strings.Cut(authorization, " "); ok {
+		switch {
+		case a.User != "" && strings.EqualFold(scheme, "Basic"):
+			if user, password, ok := decodeBasic(credentials); ok && secureEqual(user, a.User) && secureEqual(password, a.Password) {
+				result.ok = true
+			}
+		case a.Token != "" && strings.EqualFold(scheme, "Bearer"):
+			result.ok = secureEqual(strings.TrimSpace(credentials), a.Token)
+		}
+		if result.ok {
+			result.headers.Del("Authorization")
+			return result
+		}
+	}
+	if a.Token == "" {
+		return result
+	}
+
+	cookies := (&http.Request{Header: http.Header{"Cookie": cookieValues(headers)}}).Cookies()
+	for _, cookie := range cookies {
+		if cookie.Name == authTokenName && secureEqual(cookie.Value, a.Token) {
+			result.ok = true
+			dropCookies(result.headers, authTokenName)
+			return result
+		}
+	}
+
+	if u, err := url.Parse(path); err == nil {
+		query := u.Query()
+		if query.Has(authTokenName) && secureEqual(query.Get(authTokenName), a.Token) {
+			query.Del(authTokenName)
+			u.RawQuery = query.Encode()
+			result.ok = true
+			result.path = u.RequestURI()
+			result.setCookie = true
+		}
+	}
+	return result
+}
+
+// redact removes anything that looks like the gate's credentials, valid or
+// not, from a request as it is kept by the inspector, history and captures
+func (a *Auth) redact(path string, headers Header) (string, Header) {
+	if a == nil {
+		return path, headers
+	}
+	headers = headers.clone()
+	if scheme, _, ok := strings.Cut(headers.Get("Authorization"), " "); ok {
+		if a.User != "" && strings.EqualFold(scheme, "Basic") || a.Token != "" && strings.EqualFold(scheme, "Bearer") {
+			headers.Del("Authorization")
+		}
+	}
+	if a.Token == "" {
+		return path, headers
+	}
+	dropCookies(headers, authTokenName)
+	if u, err := url.Parse(path); err == nil && u.Query().Has(authTokenName) {
+		query := u.Query()
+		query.Del(authTokenName)
+		u.RawQuery = query.Encode()
+		path = u.RequestURI()
+	}
+	return path, headers
+}
+
+// challenges are the WWW-Authenticate values of a 401 response
+func (a *Auth) challenges() []string {
+	var challenges []string
+	if a.User != "" {
+		challenges = append(challenges, `Basic realm="devpipe", charset="UTF-8"`)
+	}
+	if a.Token != "" {
+		challenges = append(challenges, `Bearer realm="devpipe"`)
+	}
+	return challenges
+}
+
+// cookie keeps a token given in the query for the rest of the browser session
+func (a *Auth) cookie() string {
+	return (&http.Cookie{
+		Name:     authTokenName,
+		Value:    a.Token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}).String()
+}
+
+// sendUnauthorized rejects a request without contacting the upstream
+func sendUnauthorized(s *session, req IncomingRequest) {
+	log.Printf("🔒 Rejected %s %s: missing or invalid credentials", req.Method, req.Path)
+	response := OutgoingResponse{
+		ID:     req.ID,
+		Status: http.StatusUnauthorized,
+		Headers: Header{
+			"Content-Type":     {"text/plain"},
+			"Www-Authenticate": s.opts.Auth.challenges(),
+		},
+		Body: "Unauthorized",
+	}
+	s.printRequest(req.Method, req.Path, response.Status, "AUTH")
+
+	if err := s.send(response); err != nil {
+		log.Printf("❌ Error sending error response: %v", err)
+	}
+}
+
+func decodeBasic(credentials string) (string, string, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func cookieValues(headers Header) []string {
+	for k, v := range headers {
+		if strings.EqualFold(k, "Cookie") {
+			return v
+		}
+	}
+	return nil
+}
+
+// dropCookies removes the named cookies from the Cookie header of h
+func dropCookies(h Header, names ...string) {
+	cookies := (&http.Request{Header: http.Header{"Cookie": cookieValues(h)}}).Cookies()
+	h.Del("Cookie")
+	if rest := withoutCookie(cookies, names...); rest != "" {
+		h.Set("Cookie", rest)
+	}
+}
+
+// withoutCookie rebuilds a Cookie header without the named cookies
+func withoutCookie(cookies []*http.Cookie, names ...string) string {
+	parts := make([]string, 0, len(cookies))
+next:
+	for _, cookie := range cookies {
+		for _, name := range names {
+			if cookie.Name == name {
+				continue next
+			}
+		}
+		parts = append(parts, cookie.Name+"="+cookie.Value)
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Why a request context was canceled, as its cause
+var (
+	errCanceledByServer = errors.New("canceled by the server")
+	errRequestTimeout   = errors.New("request timed out")
+	errSessionClosed    = errors.New("tunnel connection closed")
+	errShuttingDown     = errors.New("tunnel shutting down")
+)
+
+// RequestCancel tells the client a visitor went away, so the local request
+// can be aborted instead of running until the app answers
+type RequestCancel struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// requestContexts tracks the cancel functions of the requests in flight
+type requestContexts struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelCauseFunc
+}
+
+func newRequestContexts() *requestContexts {
+	return &requestContexts{cancels: make(map[string]context.CancelCauseFunc)}
+}
+
+// open returns the context of a request, canceled when timeout is set and
+// the app takes longer to answer. answered stops that clock once the response
+// headers arrive, so long downloads and event streams aren't cut off. done
+// must be called once the request is over to free it
+func (r *requestContexts) open(id string, timeout time.Duration) (ctx context.Context, answered, done func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	stop := func() {}
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() { cancel(errRequestTimeout) })
+		stop = func() { timer.Stop() }
+	}
+
+	r.mu.Lock()
+	r.cancels[id] = cancel
+	r.mu.Unlock()
+
+	return ctx, stop, func() {
+		r.mu.Lock()
+		delete(r.cancels, id)
+		r.mu.Unlock()
+		stop()
+		cancel(nil)
+	}
+}
+
+// cancel aborts one request, reporting whether it was still in flight
+func (r *requestContexts) cancel(id string, cause error) bool {
+	r.mu.Lock()
+	cancel, ok := r.cancels[id]
+	r.mu.Unlock()
+	if ok {
+		cancel(cause)
+	}
+	return ok
+}
+
+// cancelAll aborts every request in flight
+func (r *requestContexts) cancelAll(cause error) {
+	r.mu.Lock()
+	cancels := r.cancels
+	r.cancels = make(map[string]context.CancelCauseFunc)
+	r.mu.Unlock()
+
+	for _, cancel := range cancels {
+		cancel(cause)
+	}
+}
+
+// handleCanceled finishes a request whose context ended before the app
+// answered. A timeout gets a 504; anything else means nobody is waiting for
+// the response anymore, so none is sent
+func handleCanceled(s *session, req IncomingRequest, cause error) {
+	if cause == errRequestTimeout {
+		log.Printf("⏱️  %s %s timed out", req.Method, req.Path)
+		s.printRequest(req.Method, req.Path, http.StatusGatewayTimeout, "TIMEOUT")
+		sendErrorResponse(s, req.ID, "Upstream timed out", http.StatusGatewayTimeout)
+		return
+	}
+
+	log.Printf("🚫 %s %s %v", req.Method, req.Path, cause)
+	s.printRequest(req.Method, req.Path, 499, "CANCEL")
+	s.observeCanceled(req.ID, cause.Error())
+}
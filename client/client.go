@@ -1,32 +1,46 @@
 package client
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/panngo/devpipe-cli/capture"
 	"github.com/panngo/devpipe-cli/config"
+	"github.com/panngo/devpipe-cli/inspector"
+	"github.com/panngo/devpipe-cli/replay"
 	"github.com/panngo/devpipe-cli/ws"
 )
 
 type IncomingRequest struct {
-	ID      string            `json:"id"`
-	Method  string            `json:"method"`
-	Path    string            `json:"path"`
-	Headers map[string]string `json:"headers"`
-	Body    string            `json:"body"`
+	ID           string `json:"id"`
+	Method       string `json:"method"`
+	Path         string `json:"path"`
+	Headers      Header `json:"headers"`
+	Body         string `json:"body"`
+	BodyEncoding string `json:"body_encoding,omitempty"`
+	// ClientIP is the visitor address as seen by the edge, when it reports it
+	ClientIP string `json:"client_ip,omitempty"`
+	// BodyStream means the body follows in request_chunk frames
+	BodyStream bool `json:"body_stream,omitempty"`
 }
 
 type OutgoingResponse struct {
-	ID      string            `json:"id"`
-	Status  int               `json:"status"`
-	Headers map[string]string `json:"headers"`
-	Body    string            `json:"body"`
+	ID           string `json:"id"`
+	Status       int    `json:"status"`
+	Headers      Header `json:"headers"`
+	Body         string `json:"body"`
+	BodyEncoding string `json:"body_encoding,omitempty"`
 }
 
 // Supported HTTP methods that browsers can send
@@ -50,27 +64,297 @@ var methodsWithBody = map[string]bool{
 	"DELETE": false, // DELETE can have body but often doesn't
 }
 
-func ParseFlags() string {
+// Options holds the settings used to run a tunnel
+type Options struct {
+	// Name is set for tunnels started from a tunnel file
+	Name string
+	// Profile selects the saved credentials of the tunnel
+	Profile  string
+	Port     string
+	Upstream *Upstream
+	Routes   []Route
+	CORS     *CORS
+	Auth     *Auth
+	IPFilter *IPFilter
+	OAuth    *OAuthGate
+	Webhook  *WebhookVerifier
+	// RequestHeaders and ResponseHeaders rewrite headers on the way to and from the app
+	RequestHeaders  HeaderRules
+	ResponseHeaders HeaderRules
+	ServerURL       string
+	InspectAddr     string
+	Inspector       *inspector.Store
+	History         *replay.Store
+	Recorder        *capture.Recorder
+	persist         *persister
+	// RequestTimeout aborts requests the app takes longer to start answering,
+	// up to the response headers; zero means no limit
+	RequestTimeout time.Duration
+	// ShutdownTimeout is how long Ctrl-C waits for requests in flight
+	ShutdownTimeout time.Duration
+	// Reconnect is how the tunnel retries when its connection drops
+	Reconnect ReconnectPolicy
+	// Limiter caps the requests sent to the app at once
+	Limiter *requestLimiter
+}
+
+func ParseFlags() Options {
 	port := flag.String("port", "3000", "Local port to forward to")
+	upstream := flag.String("upstream", "", "Forward to this URL instead of localhost, e.g. https://api.internal:8443")
+	upstreamOpts := upstreamFlags(flag.CommandLine)
+	upstreamInsecure := flag.Bool("upstream-insecure", false, "Skip TLS certificate verification for the upstream")
+	upstreamCA := flag.String("upstream-ca", "", "PEM file with extra CA certificates to trust for the upstream")
+	server := flag.String("server", "", "DevPipe server WebSocket URL (env DEVPIPE_SERVER)")
+	inspect := flag.String("inspect", inspector.DefaultAddr, "Address for the request inspector UI (empty to disable)")
+	history := flag.Bool("history", true, "Keep recent requests on disk for devpipe replay")
+	record := flag.String("record", "", "Append every request/response pair to this JSONL capture file")
+	var routes stringList
+	flag.Var(&routes, "route", "Send matching paths to another upstream, e.g. '/api/*=:8080,strip' (repeatable)")
+	corsMode := flag.String("cors", CORSPassthrough, "CORS handling: passthrough (the app answers), auto or policy")
+	corsOrigins := flag.String("cors-origins", "", "Comma-separated origins allowed by the CORS policy (* for any)")
+	corsMethods := flag.String("cors-methods", "", "Comma-separated methods allowed by the CORS policy")
+	corsHeaders := flag.String("cors-headers", "", "Comma-separated request headers allowed by the CORS policy (default: any)")
+	corsCredentials := flag.Bool("cors-credentials", false, "Allow credentials in the auto or policy CORS modes")
+	basicAuth := flag.String("basic-auth", "", "Require HTTP basic auth, as user:pass")
+	authToken := flag.String("auth-token", "", "Require this token as a Bearer header, devpipe_token cookie or query parameter")
+	var allowCIDRs, denyCIDRs stringList
+	flag.Var(&allowCIDRs, "allow-cidr", "Only accept visitors from these ranges, e.g. 203.0.113.0/24 (repeatable)")
+	flag.Var(&denyCIDRs, "deny-cidr", "Reject visitors from these ranges (repeatable)")
+	oauthProvider := flag.String("oauth-provider", "", "Require visitors to log in with google, github or oidc")
+	oauthIssuer := flag.String("oauth-issuer", "", "OIDC issuer URL, for -oauth-provider oidc")
+	oauthClientID := flag.String("oauth-client-id", "", "OAuth client ID")
+	oauthClientSecret := flag.String("oauth-client-secret", "", "OAuth client secret (env DEVPIPE_OAUTH_CLIENT_SECRET)")
+	var oauthDomains, oauthEmails stringList
+	flag.Var(&oauthDomains, "oauth-allow-domain", "Let logged in users with emails in this domain through (repeatable)")
+	flag.Var(&oauthEmails, "oauth-allow-email", "Let this logged in email address through (repeatable)")
+	verifyWebhook := flag.String("verify-webhook", "", "Reject webhooks without a valid signature: stripe, github, slack or hmac, as provider:secret (hmac can't detect replays)")
+	webhookHeader := flag.String("webhook-header", DefaultWebhookHeader, "Header with the signature of hmac webhooks")
+	var requestHeaders, responseHeaders headerRuleList
+	flag.Var(&requestHeaders, "request-header", "Rewrite a request header: 'set NAME: VALUE', 'add NAME: VALUE' or 'remove NAME' (repeatable)")
+	flag.Var(&responseHeaders, "response-header", "Rewrite a response header, e.g. 'add X-Robots-Tag: noindex' (repeatable)")
+	requestTimeout := flag.Duration("request-timeout", 0, "Abort requests the app takes longer than this to start answering, e.g. 30s (default: no limit)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", DefaultShutdownTimeout, "How long Ctrl-C waits for requests in flight before closing the tunnel")
+	maxConcurrent := flag.Int("max-concurrent", DefaultMaxConcurrent, "Requests sent to the app at once (0 for no limit)")
+	maxQueue := flag.Int("max-queue", DefaultMaxQueue, "Requests waiting for the app before new ones get a 503")
+	reconnectPolicy := reconnectFlags(flag.CommandLine)
+	profile := flag.String("profile", "", "Name for the saved tunnel credentials (default: one per port)")
 	clearConfig := flag.Bool("clear-config", false, "Clear saved tunnel configuration")
 	flag.Parse()
 	
+	configManager := config.NewConfigManager()
+
+	routeSpecs := make([]config.RouteSpec, 0, len(routes))
+	for _, value := range routes {
+		spec, err := config.ParseRoute(value)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		routeSpecs = append(routeSpecs, spec)
+	}
+	spec := config.TunnelSpec{
+		Port:             *port,
+		Upstream:         *upstream,
+		UpstreamInsecure: *upstreamInsecure,
+		UpstreamCA:       *upstreamCA,
+		Routes:           routeSpecs,
+		CORS: config.CORSSpec{
+			Mode:        *corsMode,
+			Origins:     splitList(*corsOrigins),
+			Methods:     splitList(*corsMethods),
+			Headers:     splitList(*corsHeaders),
+			Credentials: *corsCredentials,
+		},
+		BasicAuth: *basicAuth,
+		AuthToken: *authToken,
+		AllowCIDR: allowCIDRs,
+		DenyCIDR:  denyCIDRs,
+		OAuth: config.OAuthSpec{
+			Provider:     *oauthProvider,
+			Issuer:       *oauthIssuer,
+			ClientID:     *oauthClientID,
+			ClientSecret: *oauthClientSecret,
+			AllowDomains: oauthDomains,
+			AllowEmails:  oauthEmails,
+		},
+		VerifyWebhook:   *verifyWebhook,
+		WebhookHeader:   *webhookHeader,
+		RequestHeaders:  requestHeaders,
+		ResponseHeaders: responseHeaders,
+	}
+
+	shared := baseOptions(resolveServerURL(*server, configManager), *inspect, *history, *record, configManager)
+	shared.RequestTimeout = *requestTimeout
+	shared.ShutdownTimeout = *shutdownTimeout
+	reconnect, err := resolveReconnectPolicy(flag.CommandLine, *reconnectPolicy, configManager)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	shared.Reconnect = reconnect
+	opts, err := tunnelOptions(shared, spec, tunnelDefaults{
+		upstream:      *upstreamOpts,
+		maxConcurrent: *maxConcurrent,
+		maxQueue:      *maxQueue,
+	}, configManager)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	// Credentials are kept per profile so tunnels on other ports don't clash
+	if *profile == "" {
+		*profile = opts.Upstream.Profile()
+	}
+	if err := config.ValidateProfile(*profile); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	profileConfig := configManager.ForProfile(*profile)
+	if err := profileConfig.ImportLegacyTunnelConfig(opts.Port); err != nil {
+		log.Printf("⚠️  Warning: Could not import saved tunnel configuration: %v", err)
+	}
+
 	// Handle clear config flag
 	if *clearConfig {
-		configManager := config.NewConfigManager()
-		if err := configManager.ClearTunnelConfig(); err != nil {
+		if err := profileConfig.ClearTunnelConfig(); err != nil {
 			log.Printf("❌ Failed to clear tunnel configuration: %v", err)
 		} else {
 			log.Println("🗑️  Tunnel configuration cleared successfully")
 		}
 	}
-	
-	return *port
+
+	opts.Profile = *profile
+	// Replays go to the same upstreams, so they need the same TLS and connection settings
+	transports := upstreamTransports{}
+	transports.addAll(opts)
+	opts.Inspector.ReplayClient = replay.NewClient(transports)
+	return opts
 }
 
-func ListenAndServe(conn *ws.SafeConn, port string) {
-	serverUrl := "wss://devpipe.cloud/ws"
-	
+// baseOptions builds the settings shared by every tunnel of the process.
+// The in-memory history backs both the inspector UI and replays
+func baseOptions(serverURL, inspect string, history bool, record string, configManager *config.ConfigManager) Options {
+	opts := Options{
+		ServerURL:   serverURL,
+		InspectAddr: inspect,
+		Inspector:   inspector.NewStore(inspector.DefaultCapacity),
+	}
+	if history {
+		opts.History = replay.NewStore(configManager.HistoryDir(), replay.DefaultMaxRecords)
+	}
+	if record != "" {
+		recorder, err := capture.NewRecorder(record)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		opts.Recorder = recorder
+		log.Printf("📼 Recording traffic to %s", record)
+	}
+	opts.persist = newPersister(opts.History, opts.Recorder)
+	return opts
+}
+
+// Close finishes saving the requests already answered and closes the
+// capture file. Call it once every tunnel sharing these options has stopped
+func (o Options) Close() {
+	o.persist.close()
+}
+
+// tunnelDefaults are the flag settings every tunnel of the process starts from
+type tunnelDefaults struct {
+	upstream      UpstreamOptions
+	maxConcurrent int
+	maxQueue      int
+}
+
+// tunnelOptions builds the options of one tunnel from its spec, on top of the
+// settings shared by the process. The flags of a single tunnel and each
+// entry of a tunnel file both go through here
+func tunnelOptions(shared Options, spec config.TunnelSpec, defaults tunnelDefaults, configManager *config.ConfigManager) (Options, error) {
+	upstreamURL := spec.Upstream
+	if upstreamURL == "" {
+		upstreamURL = localUpstream(spec.Port)
+	}
+	upstreamOpts := defaults.upstream
+	upstreamOpts.Insecure = spec.UpstreamInsecure
+	upstreamOpts.CAFile = spec.UpstreamCA
+	upstream, err := NewUpstream(upstreamURL, upstreamOpts)
+	if err != nil {
+		return Options{}, err
+	}
+	routes, err := newRoutes(spec.Routes, upstreamOpts)
+	if err != nil {
+		return Options{}, err
+	}
+	cors, err := newCORS(spec.CORS)
+	if err != nil {
+		return Options{}, err
+	}
+	auth, err := newAuth(spec.BasicAuth, spec.AuthToken)
+	if err != nil {
+		return Options{}, err
+	}
+	ipFilter, err := newIPFilter(spec.AllowCIDR, spec.DenyCIDR)
+	if err != nil {
+		return Options{}, err
+	}
+	requestRules, err := newHeaderRules(spec.RequestHeaders)
+	if err != nil {
+		return Options{}, err
+	}
+	responseRules, err := newHeaderRules(spec.ResponseHeaders)
+	if err != nil {
+		return Options{}, err
+	}
+	webhook, err := newWebhookVerifier(spec.VerifyWebhook, spec.WebhookHeader)
+	if err != nil {
+		return Options{}, err
+	}
+	oauth, err := newOAuthGate(spec.OAuth, configManager)
+	if err != nil {
+		return Options{}, err
+	}
+	limiter, err := newRequestLimiter(defaults.maxConcurrent, defaults.maxQueue)
+	if err != nil {
+		return Options{}, err
+	}
+
+	opts := shared
+	opts.Name = spec.Name
+	opts.Profile = spec.Name
+	opts.Port = upstream.Port()
+	opts.Upstream = upstream
+	opts.Routes = routes
+	opts.CORS = cors
+	opts.Auth = auth
+	opts.IPFilter = ipFilter
+	opts.OAuth = oauth
+	opts.Webhook = webhook
+	opts.RequestHeaders = requestRules
+	opts.ResponseHeaders = responseRules
+	opts.Limiter = limiter
+	opts.Inspector.AddLoad(limiter.load(spec.Name))
+	return opts, nil
+}
+
+// resolveServerURL picks the server URL from the flag, the DEVPIPE_SERVER
+// env var or the settings file, in that order, falling back to the default
+func resolveServerURL(flagValue string, configManager *config.ConfigManager) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if env := os.Getenv("DEVPIPE_SERVER"); env != "" {
+		return env
+	}
+	settings, err := configManager.LoadSettings()
+	if err != nil {
+		log.Printf("⚠️  Warning: Could not load settings: %v", err)
+	} else if settings.ServerURL != "" {
+		return settings.ServerURL
+	}
+	return config.DefaultServerURL
+}
+
+// ListenAndServe serves the tunnel, reconnecting when the connection drops,
+// until ctx is canceled and the shutdown has drained the requests in flight
+func ListenAndServe(ctx context.Context, conn *ws.SafeConn, opts Options) {
 	// Store the initial tunnel ID and UUID
 	tunnelID := conn.GetTunnelID()
 	uuid := conn.GetUUID()
@@ -79,7 +363,28 @@ func ListenAndServe(conn *ws.SafeConn, port string) {
 	if uuid != "" {
 		log.Printf("🔑 UUID: %s", uuid)
 	}
+
+	// sessMu guards sess, which the shutdown reads while reconnects replace it
+	var sessMu sync.Mutex
+	sess := newSession(conn, opts)
+	defer func() { sess.close() }()
 	
+	finished := make(chan struct{})
+	defer close(finished)
+	shutdownDone := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-finished:
+			return
+		}
+		sessMu.Lock()
+		current := sess
+		sessMu.Unlock()
+		current.shutdown(opts.ShutdownTimeout)
+		close(shutdownDone)
+	}()
+
 	// Configure heartbeat
 	heartbeatTicker := time.NewTicker(30 * time.Second)
 	defer heartbeatTicker.Stop()
@@ -107,40 +412,59 @@ func ListenAndServe(conn *ws.SafeConn, port string) {
 			conn.SetReadDeadline(time.Now().Add(35 * time.Second))
 			_, msg, err := conn.ReadMessage()
 			if err != nil {
-				log.Println("❌ WebSocket read error:", err)
+				if ctx.Err() == nil {
+					log.Println("❌ WebSocket read error:", err)
+				}
 				goto reconnect
 			}
 			
 			// Reset deadline after successful read
 			conn.SetReadDeadline(time.Time{})
 			
-			var req IncomingRequest
-			if err := json.Unmarshal(msg, &req); err != nil {
-				log.Println("❌ JSON unmarshal error:", err)
-				continue
-			}
-
-			go handleRequest(conn, req, port)
+			sess.dispatch(msg)
 		}
 		continue
 	
 	reconnect:
+		// A shutdown closes the connection on purpose
+		if ctx.Err() != nil {
+			<-shutdownDone
+			log.Println("👋 Tunnel closed")
+			return
+		}
 		log.Println("🔄 Attempting to reconnect...")
 		
 		// Stop heartbeat temporarily
 		heartbeatTicker.Stop()
 		
 		// Try to reconnect
-		newConn, newTunnelID := reconnect(serverUrl, port, tunnelID, uuid)
+		newConn, newTunnelID := reconnect(ctx, opts, tunnelID, uuid)
+		if newConn == nil && ctx.Err() != nil {
+			<-shutdownDone
+			log.Println("👋 Tunnel closed")
+			return
+		}
 		if newConn == nil {
 			log.Println("❌ Failed to reconnect, exiting...")
 			return
 		}
 		
+		if ctx.Err() != nil {
+			newConn.Close()
+			<-shutdownDone
+			return
+		}
+
 		conn = newConn
 		tunnelID = newTunnelID
 		uuid = conn.GetUUID()
 		
+		// Streams in flight belonged to the old connection
+		sessMu.Lock()
+		sess.close()
+		sess = newSession(conn, opts)
+		sessMu.Unlock()
+
 		if tunnelID == conn.GetTunnelID() {
 			log.Printf("✅ Successfully reconnected with same tunnel: %s", tunnelID)
 		} else {
@@ -160,39 +484,52 @@ func ListenAndServe(conn *ws.SafeConn, port string) {
 	}
 }
 
-func reconnect(serverUrl, port, previousTunnelID, previousUUID string) (*ws.SafeConn, string) {
-	maxRetries := 5
-	retryDelay := time.Second * 2
+// reconnect registers the tunnel again, retrying as its reconnect policy
+// says. It returns nil once the attempts run out or ctx is canceled
+func reconnect(ctx context.Context, opts Options, previousTunnelID, previousUUID string) (*ws.SafeConn, string) {
+	serverUrl := opts.ServerURL
+	port := opts.Port
+	profile := opts.Profile
+	policy := opts.Reconnect
 	
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		log.Printf("🔄 Reconnection attempt %d/%d...", attempt, maxRetries)
+	for attempt := 1; ; attempt++ {
+		if policy.MaxAttempts > 0 {
+			log.Printf("🔄 Reconnection attempt %d/%d...", attempt, policy.MaxAttempts)
+		} else {
+			log.Printf("🔄 Reconnection attempt %d...", attempt)
+		}
 		
 		var conn *ws.SafeConn
 		var tunnelID string
 		var err error
 		
-		// If we have a previous UUID, try secure reconnection first
-		if previousUUID != "" && attempt == 1 {
+		// If we have a previous UUID, try secure reconnection first, on every
+		// attempt: a server that is down or restarting doesn't make the
+		// credentials invalid, only the server turning them down does
+		if previousUUID != "" {
 			log.Printf("🔐 Attempting secure reconnection with UUID: %s", previousUUID)
-			conn, tunnelID, err = ws.ConnectAndReconnect(serverUrl, port, previousTunnelID)
+			conn, tunnelID, err = ws.ConnectAndReconnect(serverUrl, port, profile, previousTunnelID)
 			if err == nil {
 				log.Printf("✅ Secure reconnection successful")
 				return conn, tunnelID
-			} else {
-				log.Printf("❌ Secure reconnection failed: %v", err)
-				// Clear invalid configuration
-				configManager := config.NewConfigManager()
-				if clearErr := configManager.ClearTunnelConfig(); clearErr != nil {
-					log.Printf("⚠️  Warning: Could not clear invalid config: %v", clearErr)
-				} else {
-					log.Printf("🗑️  Cleared invalid tunnel configuration")
-				}
+			}
+			log.Printf("❌ Secure reconnection failed: %v", err)
+			if errors.Is(err, ws.ErrRejected) {
+				clearTunnelConfig(profile)
+				previousUUID = ""
+			} else if errors.Is(err, ws.ErrNoTunnelConfig) {
+				previousUUID = ""
 			}
 		}
 		
-		// Fallback to new registration
-		log.Println("🆕 Attempting new registration...")
-		conn, tunnelID, err = ws.ConnectAndRegisterWithRetry(serverUrl, port)
+		// Fallback to new registration, unless the server was unreachable
+		if previousUUID == "" {
+			log.Println("🆕 Attempting new registration...")
+			conn, tunnelID, err = ws.ConnectAndRegisterWithRetry(serverUrl, port, profile)
+			if errors.Is(err, ws.ErrRejected) {
+				clearTunnelConfig(profile)
+			}
+		}
 		
 		if err == nil {
 			// If we had a previous tunnel ID and the new one is different, log this
@@ -205,60 +542,143 @@ func reconnect(serverUrl, port, previousTunnelID, previousUUID string) (*ws.Safe
 		}
 		
 		log.Printf("❌ Reconnection attempt %d failed: %v", attempt, err)
+		if policy.exhausted(attempt) {
+			return nil, ""
+		}
 		
-		if attempt < maxRetries {
-			log.Printf("⏳ Waiting %v before next attempt...", retryDelay)
-			time.Sleep(retryDelay)
-			// Increase delay exponentially
-			retryDelay = time.Duration(float64(retryDelay) * 1.5)
+		delay := policy.delay(attempt)
+		log.Printf("⏳ Waiting %v before next attempt...", delay.Round(100*time.Millisecond))
+		if !waitToReconnect(ctx, opts.Name, attempt, delay) {
+			return nil, ""
 		}
 	}
-	
-	return nil, ""
 }
 
-func handleRequest(conn *ws.SafeConn, req IncomingRequest, port string) {
+// clearTunnelConfig forgets the credentials the server turned down, so the
+// next registration asks for a new tunnel
+func clearTunnelConfig(profile string) {
+	configManager := config.NewConfigManager().ForProfile(profile)
+	if err := configManager.ClearTunnelConfig(); err != nil {
+		log.Printf("⚠️  Warning: Could not clear invalid config: %v", err)
+	} else {
+		log.Printf("🗑️  Cleared invalid tunnel configuration")
+	}
+}
+
+func handleRequest(s *session, req IncomingRequest) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("❌ Panic in request handler: %v", r)
 		}
 	}()
+	// Release the streamed body, if any, whichever way the request ends
+	defer s.uploads.remove(req.ID)
+	// A cancel from the server, the request deadline or a shutdown aborts the upstream call
+	ctx, answered, done := s.requests.open(req.ID, s.opts.RequestTimeout)
+	defer done()
+	// The place taken by admit is given back however the request ends
+	slot := s.opts.Limiter.slot()
+	defer slot.release()
 	
 	// Validate HTTP method
 	if !isValidHTTPMethod(req.Method) {
 		log.Printf("❌ Unsupported HTTP method: %s", req.Method)
-		sendErrorResponse(conn, req.ID, fmt.Sprintf("Unsupported HTTP method: %s", req.Method), 405)
+		sendErrorResponse(s, req.ID, fmt.Sprintf("Unsupported HTTP method: %s", req.Method), 405)
 		return
 	}
 	
 	// Validate request path
 	if req.Path == "" {
 		log.Printf("❌ Empty request path")
-		sendErrorResponse(conn, req.ID, "Empty request path", 400)
+		sendErrorResponse(s, req.ID, "Empty request path", 400)
 		return
 	}
 	
-	// Handle special methods
-	if req.Method == "OPTIONS" {
-		handleOptionsRequest(conn, req, port)
+	// Blocked addresses get nothing, not even a CORS preflight
+	ip := s.clientIP(req.ClientIP, req.Headers)
+	if ok, reason := s.opts.IPFilter.check(ip); !ok {
+		sendForbidden(s, req, ip, reason)
+		return
+	}
+
+	// Upgrades can't be answered as a single response; the server must use ws_open
+	if isWebSocketUpgrade(req.Headers) {
+		log.Printf("❌ WebSocket upgrade for %s sent as a plain request", req.Path)
+		sendErrorResponse(s, req.ID, "WebSocket passthrough is not supported by this server", 501)
+		return
+	}
+
+	// OPTIONS reaches the app unless devpipe is set to answer CORS preflights
+	if s.opts.CORS.isPreflight(req) {
+		handlePreflight(s, req)
 		return
 	}
 	
-	url := "http://localhost:" + port + req.Path
+	// Requests without valid credentials never reach the upstream
+	gate := s.opts.Auth.check(req.Path, req.Headers)
+	if !gate.ok {
+		sendUnauthorized(s, req)
+		return
+	}
+	req.Path, req.Headers = gate.path, gate.headers
+
+	// With a login provider set, visitors without a session are sent to log in
+	headers, ok := s.opts.OAuth.gate(s, req)
+	if !ok {
+		return
+	}
+	req.Headers = headers
+
+	upstream, path := s.route(req.Path)
+	target := upstream.Target()
+	url := target + path
+	s.observeTarget(req.ID, target, path)
 	
+	reqBody, err := decodeBody(req.Body, req.BodyEncoding)
+	if err != nil {
+		log.Printf("❌ Error decoding request body: %v", err)
+		sendErrorResponse(s, req.ID, "Invalid request body", 400)
+		return
+	}
+
 	// Create request with appropriate body handling
 	var httpReq *http.Request
-	var err error
+	upload := s.uploads.get(req.ID)
 	
-	if shouldHaveBody(req.Method) && req.Body != "" {
-		httpReq, err = http.NewRequest(req.Method, url, strings.NewReader(req.Body))
+	// The signature covers the whole body, so streamed webhooks are buffered first
+	if s.opts.Webhook != nil {
+		if upload != nil {
+			if reqBody, err = readWebhookBody(upload.reader); err != nil {
+				log.Printf("❌ Error reading webhook body: %v", err)
+				sendErrorResponse(s, req.ID, "Failed to read webhook body", 413)
+				return
+			}
+			upload = nil
+		}
+		if !verifyWebhook(s, req, reqBody) {
+			return
+		}
+	}
+
+	// Only requests that passed the local checks wait for room at the app;
+	// the wait counts towards the request timeout
+	if !slot.acquire(ctx, req) {
+		handleCanceled(s, req, context.Cause(ctx))
+		return
+	}
+
+	if upload != nil {
+		// Streamed upload: the local app reads the body as its chunks arrive
+		httpReq, err = http.NewRequestWithContext(ctx, req.Method, url, upload.reader)
+	} else if shouldHaveBody(req.Method) && len(reqBody) > 0 {
+		httpReq, err = http.NewRequestWithContext(ctx, req.Method, url, bytes.NewReader(reqBody))
 	} else {
-		httpReq, err = http.NewRequest(req.Method, url, nil)
+		httpReq, err = http.NewRequestWithContext(ctx, req.Method, url, nil)
 	}
 	
 	if err != nil {
 		log.Printf("❌ Error creating local request for %s: %v", url, err)
-		sendErrorResponse(conn, req.ID, "Failed to create request", 500)
+		sendErrorResponse(s, req.ID, "Failed to create request", 500)
 		return
 	}
 	
@@ -277,63 +697,95 @@ func handleRequest(conn *ws.SafeConn, req IncomingRequest, port string) {
 			// Skip Transfer-Encoding, let Go handle it
 			continue
 		}
-		// Copy all other headers exactly as received
-		httpReq.Header.Set(k, v)
+		// Copy all other headers exactly as received, keeping repeated values in order
+		for _, value := range v {
+			httpReq.Header.Add(k, value)
+		}
 	}
 	
+	// A known length lets the local app see Content-Length instead of a chunked body
+	if upload != nil {
+		if length, err := strconv.ParseInt(httpReq.Header.Get("Content-Length"), 10, 64); err == nil {
+			httpReq.ContentLength = length
+		}
+	}
+
+	values := s.headerValues(ip)
+	s.rewriteRequest(httpReq, values)
+
 	// Log the request for debugging
 	log.Printf("🌐 HTTP %s %s", req.Method, req.Path)
-	if req.Body != "" {
-		log.Printf("📦 Request body length: %d bytes", len(req.Body))
+	if upload != nil {
+		log.Printf("📦 Streaming request body")
+	} else if len(reqBody) > 0 {
+		log.Printf("📦 Request body length: %d bytes", len(reqBody))
 	}
 
-	resp, err := http.DefaultClient.Do(httpReq)
+	resp, err := upstream.Client.Do(httpReq)
+	if err != nil && ctx.Err() != nil {
+		handleCanceled(s, req, context.Cause(ctx))
+		return
+	}
+	if err != nil && isTimeout(err) {
+		// The dial or response header timeout of the upstream transport
+		handleCanceled(s, req, errRequestTimeout)
+		return
+	}
 	if err != nil {
 		log.Printf("❌ Request failed: %v", err)
-		sendErrorResponse(conn, req.ID, "Request failed", 502)
+		sendErrorResponse(s, req.ID, "Request failed", 502)
 		return
 	}
 	defer resp.Body.Close()
+	// The timeout covers the wait for an answer, not the body that follows
+	answered()
+	s.opts.CORS.decorate(req.Headers, resp.Header)
+	if gate.setCookie {
+		resp.Header.Add("Set-Cookie", s.opts.Auth.cookie())
+	}
+	s.rewriteResponse(resp.Header, values)
 
 	// Handle HEAD requests specially (no body)
 	if req.Method == "HEAD" {
-		handleHeadResponse(conn, req, resp)
+		handleHeadResponse(s, req, resp)
+		return
+	}
+
+	// Large, unbounded or long-lived (SSE) bodies are relayed as they arrive.
+	// The app already answered, so the slot goes to the next request
+	if shouldStream(s, resp) {
+		slot.release()
+		streamResponse(s, req, resp)
 		return
 	}
 
 	body, err := io.ReadAll(resp.Body)
+	if err != nil && ctx.Err() != nil {
+		handleCanceled(s, req, context.Cause(ctx))
+		return
+	}
 	if err != nil {
 		log.Printf("❌ Error reading response body: %v", err)
-		sendErrorResponse(conn, req.ID, "Failed to read response", 500)
+		sendErrorResponse(s, req.ID, "Failed to read response", 500)
 		return
 	}
 
+	encodedBody, bodyEncoding := s.encodeBody(body, resp.Header)
 	response := OutgoingResponse{
-		ID:      req.ID,
-		Status:  resp.StatusCode,
-		Headers: map[string]string{},
-		Body:    string(body),
-	}
-	
-	// PROXY MODE: Copy ALL response headers exactly as received (transparent proxy)
-	for k, v := range resp.Header {
-		if len(v) > 0 {
-			// For headers that can have multiple values, join with comma
-			if len(v) > 1 {
-				response.Headers[k] = strings.Join(v, ", ")
-			} else {
-				response.Headers[k] = v[0]
-			}
-		}
+		ID:           req.ID,
+		Status:       resp.StatusCode,
+		Headers:      newHeader(resp.Header), // PROXY MODE: Copy ALL response headers exactly as received
+		Body:         encodedBody,
+		BodyEncoding: bodyEncoding,
 	}
 	
 	// Ensure Content-Length is calculated correctly
-	response.Headers["Content-Length"] = fmt.Sprintf("%d", len(body))
+	response.Headers.Set("Content-Length", fmt.Sprintf("%d", len(body)))
 	
-	fmt.Printf("%-6s %-20s %d OK\n", req.Method, req.Path, resp.StatusCode)
+	s.printRequest(req.Method, req.Path, resp.StatusCode, "OK")
 	
 	// Use a mutex or channel to ensure thread-safety in writing
-	if err := conn.WriteJSON(response); err != nil {
+	if err := s.send(response); err != nil {
 		log.Printf("❌ Error sending response: %v", err)
 	}
 }
@@ -348,72 +800,42 @@ func shouldHaveBody(method string) bool {
 	return methodsWithBody[strings.ToUpper(method)]
 }
 
-// handleOptionsRequest handles OPTIONS requests (CORS preflight)
-func handleOptionsRequest(conn *ws.SafeConn, req IncomingRequest, port string) {
-	response := OutgoingResponse{
-		ID:     req.ID,
-		Status: 200,
-		Headers: map[string]string{
-			"Access-Control-Allow-Origin":      "*",
-			"Access-Control-Allow-Methods":     "GET, POST, PUT, DELETE, PATCH, HEAD, OPTIONS, TRACE, CONNECT",
-			"Access-Control-Allow-Headers":     "Content-Type, Authorization, X-Requested-With, Accept, Origin, User-Agent, Referer",
-			"Access-Control-Max-Age":           "86400",
-			"Access-Control-Allow-Credentials": "true",
-			"Content-Length":                   "0",
-		},
-		Body: "",
-	}
-	
-	log.Printf("🌐 HTTP OPTIONS %s (CORS preflight)", req.Path)
-	fmt.Printf("%-6s %-20s %d OK\n", "OPTIONS", req.Path, 200)
-	
-	if err := conn.WriteJSON(response); err != nil {
-		log.Printf("❌ Error sending OPTIONS response: %v", err)
-	}
+// isWebSocketUpgrade reports whether the request headers ask for a WebSocket upgrade
+func isWebSocketUpgrade(headers Header) bool {
+	return strings.EqualFold(strings.TrimSpace(headers.Get("Upgrade")), "websocket")
 }
 
 // handleHeadResponse handles HEAD requests (no body)
-func handleHeadResponse(conn *ws.SafeConn, req IncomingRequest, resp *http.Response) {
+func handleHeadResponse(s *session, req IncomingRequest, resp *http.Response) {
 	response := OutgoingResponse{
 		ID:     req.ID,
 		Status: resp.StatusCode,
-		Headers: map[string]string{},
+		Headers: newHeader(resp.Header), // Copy all headers from the response
 		Body:    "", // HEAD requests should not have a body
 	}
 	
-	// Copy all headers from the response
-	for k, v := range resp.Header {
-		if len(v) > 0 {
-			if len(v) > 1 {
-				response.Headers[k] = strings.Join(v, ", ")
-			} else {
-				response.Headers[k] = v[0]
-			}
-		}
-	}
-	
 	// Ensure Content-Length is set to 0 for HEAD requests
-	response.Headers["Content-Length"] = "0"
+	response.Headers.Set("Content-Length", "0")
 	
 	log.Printf("🌐 HTTP HEAD %s (no body)", req.Path)
-	fmt.Printf("%-6s %-20s %d OK\n", "HEAD", req.Path, resp.StatusCode)
+	s.printRequest("HEAD", req.Path, resp.StatusCode, "OK")
 	
-	if err := conn.WriteJSON(response); err != nil {
+	if err := s.send(response); err != nil {
 		log.Printf("❌ Error sending HEAD response: %v", err)
 	}
 }
 
-func sendErrorResponse(conn *ws.SafeConn, reqID, message string, status int) {
+func sendErrorResponse(s *session, reqID, message string, status int) {
 	response := OutgoingResponse{
 		ID:     reqID,
 		Status: status,
-		Headers: map[string]string{
-			"Content-Type": "text/plain",
+		Headers: Header{
+			"Content-Type": {"text/plain"},
 		},
 		Body: message,
 	}
 	
-	if err := conn.WriteJSON(response); err != nil {
+	if err := s.send(response); err != nil {
 		log.Printf("❌ Error sending error response: %v", err)
 	}
 }
\ No newline at end of file
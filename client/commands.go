@@ -0,0 +1,152 @@
+package client
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/panngo/devpipe-cli/capture"
+	"github.com/panngo/devpipe-cli/config"
+	"github.com/panngo/devpipe-cli/replay"
+	"github.com/panngo/devpipe-cli/ui"
+	"github.com/panngo/devpipe-cli/ws"
+)
+
+// stringList collects a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// RunReplay implements `devpipe replay <id>`: it re-sends a recorded request
+// to the local app and prints how the new response differs from the original
+func RunReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	port := fs.String("port", "", "Send to localhost:<port> instead of the original target")
+	target := fs.String("target", "", "Send to this base URL instead of the original target")
+	upstreamOpts := upstreamFlags(fs)
+	fs.BoolVar(&upstreamOpts.Insecure, "upstream-insecure", false, "Skip TLS certificate verification")
+	fs.StringVar(&upstreamOpts.CAFile, "upstream-ca", "", "PEM file with extra CA certificates to trust")
+	body := fs.String("d", "", "Replace the request body")
+	bodyFile := fs.String("body-file", "", "Replace the request body with the contents of a file")
+	var headers stringList
+	fs.Var(&headers, "H", "Set a header, e.g. -H 'X-Debug: 1' (repeatable; empty value removes it)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: devpipe replay [options] <request-id>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("replay needs exactly one request ID")
+	}
+
+	tlsConfig, err := newTLSConfig(upstreamOpts.Insecure, upstreamOpts.CAFile)
+	if err != nil {
+		return err
+	}
+	transport, err := newUpstreamTransport(tlsConfig, *upstreamOpts)
+	if err != nil {
+		return err
+	}
+
+	store := replay.NewStore(config.NewConfigManager().HistoryDir(), replay.DefaultMaxRecords)
+	rec, err := store.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	edits := replay.Edits{Headers: map[string]string{}, Target: *target}
+	if *port != "" {
+		edits.Target = localUpstream(*port)
+	}
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return fmt.Errorf("invalid header %q, expected 'Name: value'", header)
+		}
+		edits.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	switch {
+	case *bodyFile != "":
+		data, err := os.ReadFile(*bodyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read body file: %w", err)
+		}
+		edits.Body = &data
+	case *body != "":
+		data := []byte(*body)
+		edits.Body = &data
+	}
+
+	destination := rec.Target
+	if edits.Target != "" {
+		destination = edits.Target
+	}
+	fmt.Printf("🔁 Replaying %s %s -> %s\n", rec.Method, rec.Path, destination)
+
+	resp, err := replay.Send(replay.NewClient(transport), *rec, edits)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-15s %d -> %d\n", "Status", rec.Response.Status, resp.Status)
+	fmt.Printf("%-15s %v -> %v\n", "Duration", rec.Response.Duration, resp.Duration)
+	fmt.Println()
+	ui.PrintDiff(replay.Diff(rec.Response, resp))
+	return nil
+}
+
+// RunExport implements `devpipe export --har <capture.jsonl>`, converting a
+// capture file written with -record into another format
+func RunExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	har := fs.Bool("har", false, "Export as HAR 1.2")
+	output := fs.String("o", "", "Write to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: devpipe export --har [-o file.har] <capture.jsonl>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("export needs exactly one capture file")
+	}
+	if !*har {
+		fs.Usage()
+		return errors.New("choose an export format, e.g. --har")
+	}
+
+	entries, err := capture.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if err := capture.WriteHAR(out, entries, ws.ClientVersion); err != nil {
+		return fmt.Errorf("failed to write HAR: %w", err)
+	}
+	if *output != "" {
+		fmt.Printf("📦 Exported %d requests to %s\n", len(entries), *output)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/panngo/devpipe-cli/config"
+)
+
+// CORS modes
+const (
+	// CORSPassthrough leaves CORS to the app; OPTIONS requests are forwarded
+	CORSPassthrough = "passthrough"
+	// CORSAuto answers preflights itself, reflecting the request Origin and
+	// headers. Any site can call the app this way, so credentials stay off
+	// unless asked for
+	CORSAuto = "auto"
+	// CORSPolicy answers preflights from explicit allowed origins, methods and headers
+	CORSPolicy = "policy"
+)
+
+// defaultCORSMethods are allowed by a policy that doesn't list any
+var defaultCORSMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
+
+// CORS decides how cross-origin requests are answered
+type CORS struct {
+	Mode    string
+	Origins []string
+	Methods []string
+	// Headers lists the allowed request headers; empty allows whatever the browser asks for
+	Headers     []string
+	Credentials bool
+	MaxAge      int
+}
+
+// newCORS validates a CORS spec. A policy needs at least one allowed origin
+func newCORS(spec config.CORSSpec) (*CORS, error) {
+	c := &CORS{
+		Mode:        spec.Mode,
+		Origins:     spec.Origins,
+		Methods:     spec.Methods,
+		Headers:     spec.Headers,
+		Credentials: spec.Credentials,
+		MaxAge:      spec.MaxAge,
+	}
+	switch c.Mode {
+	case "", CORSPassthrough:
+		c.Mode = CORSPassthrough
+		if len(c.Origins) > 0 {
+			return nil, fmt.Errorf("CORS origins are only used by the %s mode", CORSPolicy)
+		}
+	case CORSAuto:
+	case CORSPolicy:
+		if len(c.Origins) == 0 {
+			return nil, fmt.Errorf("CORS policy needs at least one allowed origin")
+		}
+		if len(c.Methods) == 0 {
+			c.Methods = defaultCORSMethods
+		}
+	default:
+		return nil, fmt.Errorf("invalid CORS mode %q: use %s, %s or %s", c.Mode, CORSPassthrough, CORSAuto, CORSPolicy)
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = 86400
+	}
+	return c, nil
+}
+
+// handles reports whether devpipe answers CORS itself instead of the app
+func (c *CORS) handles() bool {
+	return c != nil && c.Mode != CORSPassthrough
+}
+
+// isPreflight reports whether a request is a CORS preflight devpipe should answer.
+// Other OPTIONS requests, e.g. WebDAV or API discovery, always reach the app
+func (c *CORS) isPreflight(req IncomingRequest) bool {
+	return c.handles() &&
+		strings.EqualFold(req.Method, "OPTIONS") &&
+		req.Headers.Get("Origin") != "" &&
+		req.Headers.Get("Access-Control-Request-Method") != ""
+}
+
+// preflight builds the headers of a preflight response. A disallowed request
+// gets no CORS headers, which the browser reports as a CORS failure
+func (c *CORS) preflight(reqHeaders Header) Header {
+	headers := Header{"Content-Length": {"0"}, "Vary": {"Origin"}}
+	origin := reqHeaders.Get("Origin")
+	method := strings.ToUpper(strings.TrimSpace(reqHeaders.Get("Access-Control-Request-Method")))
+	requested := splitList(reqHeaders.Get("Access-Control-Request-Headers"))
+
+	if !c.allowsOrigin(origin) || !c.allowsMethod(method) || !c.allowsHeaders(requested) {
+		log.Printf("🚫 CORS preflight from %s for %s rejected", origin, method)
+		return headers
+	}
+
+	headers.Set("Access-Control-Allow-Origin", c.allowOrigin(origin))
+	if c.Mode == CORSAuto {
+		headers.Set("Access-Control-Allow-Methods", method)
+	} else {
+		headers.Set("Access-Control-Allow-Methods", strings.Join(c.Methods, ", "))
+	}
+	if len(requested) > 0 {
+		headers.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if c.Credentials {
+		headers.Set("Access-Control-Allow-Credentials", "true")
+	}
+	headers.Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+	return headers
+}
+
+// decorate adds CORS headers to an app response for an allowed origin
+func (c *CORS) decorate(reqHeaders Header, respHeaders http.Header) {
+	origin := reqHeaders.Get("Origin")
+	if !c.handles() || origin == "" || !c.allowsOrigin(origin) {
+		return
+	}
+	respHeaders.Set("Access-Control-Allow-Origin", c.allowOrigin(origin))
+	if c.Credentials {
+		respHeaders.Set("Access-Control-Allow-Credentials", "true")
+	} else {
+		respHeaders.Del("Access-Control-Allow-Credentials")
+	}
+	respHeaders.Add("Vary", "Origin")
+}
+
+func (c *CORS) allowsOrigin(origin string) bool {
+	if c.Mode == CORSAuto {
+		return true
+	}
+	for _, allowed := range c.Origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin is the Access-Control-Allow-Origin value. Browsers refuse a
+// wildcard on credentialed requests, so the origin is reflected instead
+func (c *CORS) allowOrigin(origin string) string {
+	if !c.Credentials {
+		for _, allowed := range c.Origins {
+			if allowed == "*" {
+				return "*"
+			}
+		}
+	}
+	return origin
+}
+
+func (c *CORS) allowsMethod(method string) bool {
+	if c.Mode == CORSAuto {
+		return method != ""
+	}
+	for _, allowed := range c.Methods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORS) allowsHeaders(requested []string) bool {
+	if c.Mode == CORSAuto || len(c.Headers) == 0 {
+		return true
+	}
+	for _, header := range requested {
+		allowed := false
+		for _, h := range c.Headers {
+			if h == "*" || strings.EqualFold(h, header) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// handlePreflight answers a CORS preflight without contacting the app
+func handlePreflight(s *session, req IncomingRequest) {
+	response := OutgoingResponse{
+		ID:      req.ID,
+		Status:  http.StatusNoContent,
+		Headers: s.opts.CORS.preflight(req.Headers),
+	}
+
+	log.Printf("🌐 HTTP OPTIONS %s (CORS preflight)", req.Path)
+	s.printRequest("OPTIONS", req.Path, response.Status, "OK")
+
+	if err := s.send(response); err != nil {
+		log.Printf("❌ Error sending OPTIONS response: %v", err)
+	}
+}
+
+// splitList splits a comma-separated header or flag value
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
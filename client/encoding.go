@@ -0,0 +1,72 @@
+package client
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// BodyEncodingBase64 marks a message body as base64-encoded bytes
+const BodyEncodingBase64 = "base64"
+
+// Media types outside text/* that are safe to send as plain strings
+var textMediaTypes = map[string]bool{
+	"application/json":                  true,
+	"application/javascript":            true,
+	"application/ecmascript":            true,
+	"application/xml":                   true,
+	"application/xhtml+xml":             true,
+	"application/x-www-form-urlencoded": true,
+	"application/graphql":               true,
+	"image/svg+xml":                     true,
+}
+
+// decodeBody returns the raw bytes of a message body. Bodies without an
+// encoding are plain strings, as sent by servers that predate body_encoding
+func decodeBody(body, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(body), nil
+	case BodyEncodingBase64:
+		data, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 body: %w", err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unsupported body encoding: %s", encoding)
+	}
+}
+
+// encodeBody prepares a body for JSON transport, returning the body string
+// and its encoding. Only valid UTF-8 text is sent as-is; anything else is base64
+func encodeBody(body []byte, contentType, contentEncoding string) (string, string) {
+	if len(body) == 0 {
+		return "", ""
+	}
+	if isTextContent(contentType, contentEncoding) && utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), BodyEncodingBase64
+}
+
+// isTextContent reports whether a response with these headers carries text
+func isTextContent(contentType, contentEncoding string) bool {
+	if contentEncoding != "" && !strings.EqualFold(contentEncoding, "identity") {
+		// gzip, br, deflate... are always binary on the wire
+		return false
+	}
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") || textMediaTypes[mediaType] {
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Header is the wire form of HTTP headers, keeping every value of a
+// repeated header (such as Set-Cookie) in order. Servers speaking protocol 1
+// send and expect a single string per key instead
+type Header map[string][]string
+
+// newHeader copies HTTP headers into their wire form
+func newHeader(h http.Header) Header {
+	header := make(Header, len(h))
+	for k, v := range h {
+		header[k] = append([]string(nil), v...)
+	}
+	return header
+}
+
+// Get returns the first value of a header, matching the key case-insensitively
+func (h Header) Get(key string) string {
+	for k, v := range h {
+		if strings.EqualFold(k, key) && len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+// Set replaces all values of a header
+func (h Header) Set(key, value string) {
+	h.Del(key)
+	h[http.CanonicalHeaderKey(key)] = []string{value}
+}
+
+// Del removes all values of a header, matching the key case-insensitively
+func (h Header) Del(key string) {
+	for k := range h {
+		if strings.EqualFold(k, key) {
+			delete(h, k)
+		}
+	}
+}
+
+// clone returns a copy that can be changed without touching h
+func (h Header) clone() Header {
+	clone := make(Header, len(h))
+	for k, v := range h {
+		clone[k] = append([]string(nil), v...)
+	}
+	return clone
+}
+
+// flatten converts to the protocol 1 form, joining repeated values with a comma
+func (h Header) flatten() map[string]string {
+	flat := make(map[string]string, len(h))
+	for k, v := range h {
+		if len(v) > 0 {
+			flat[k] = strings.Join(v, ", ")
+		}
+	}
+	return flat
+}
+
+// UnmarshalJSON accepts both the list form and the single string form
+func (h *Header) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	header := make(Header, len(raw))
+	for k, value := range raw {
+		var values []string
+		if err := json.Unmarshal(value, &values); err != nil {
+			var single string
+			if err := json.Unmarshal(value, &single); err != nil {
+				return err
+			}
+			values = []string{single}
+		}
+		header[k] = values
+	}
+	*h = header
+	return nil
+}
@@ -0,0 +1,93 @@
+package client
+
+// observeRequest starts the inspector record of a request
+func (s *session) observeRequest(req IncomingRequest) {
+	store := s.opts.Inspector
+	if store == nil {
+		return
+	}
+	id := s.exchangeID(req.ID)
+	// Gate credentials are not the app's to see, so replays and exports don't get them either
+	path, headers := s.opts.Auth.redact(req.Path, req.Headers)
+	store.Begin(id, s.opts.Name, req.Method, path, s.opts.OAuth.redact(headers))
+	if body, err := decodeBody(req.Body, req.BodyEncoding); err == nil && len(body) > 0 {
+		store.AppendRequestBody(id, body)
+	}
+}
+
+// exchangeID is the inspector ID of a request. Server request IDs are only
+// unique per tunnel, so tunnels from a tunnel file prefix them with their name
+func (s *session) exchangeID(id string) string {
+	if s.opts.Name == "" {
+		return id
+	}
+	return s.opts.Name + ":" + id
+}
+
+// observeTarget records where a request was forwarded, for replays
+func (s *session) observeTarget(id, target, path string) {
+	if s.opts.Inspector != nil {
+		s.opts.Inspector.SetTarget(s.exchangeID(id), target, path)
+	}
+}
+
+// observeWebhook records the outcome of a webhook signature check
+func (s *session) observeWebhook(id, result string) {
+	if s.opts.Inspector != nil {
+		s.opts.Inspector.SetWebhook(s.exchangeID(id), result)
+	}
+}
+
+// observeCanceled marks an exchange that ended without a response. It is
+// saved like any other, so captures show what was canceled and when
+func (s *session) observeCanceled(id, reason string) {
+	if s.opts.Inspector != nil {
+		s.opts.Inspector.Finish(s.exchangeID(id), reason)
+		s.persist(s.exchangeID(id))
+	}
+}
+
+// observeUpload records a chunk of a streamed request body
+func (s *session) observeUpload(id string, data []byte) {
+	if s.opts.Inspector != nil {
+		s.opts.Inspector.AppendRequestBody(s.exchangeID(id), data)
+	}
+}
+
+// observe records the response frames sent for a request
+func (s *session) observe(v interface{}) {
+	store := s.opts.Inspector
+	if store == nil {
+		return
+	}
+	switch msg := v.(type) {
+	case OutgoingResponse:
+		id := s.exchangeID(msg.ID)
+		store.SetResponse(id, msg.Status, msg.Headers, false)
+		if body, err := decodeBody(msg.Body, msg.BodyEncoding); err == nil && len(body) > 0 {
+			store.AppendResponseBody(id, body)
+		}
+		store.Finish(id, "")
+		s.persist(id)
+	case ResponseStart:
+		store.SetResponse(s.exchangeID(msg.ID), msg.Status, msg.Headers, true)
+	case ResponseChunk:
+		if body, err := decodeBody(msg.Body, msg.BodyEncoding); err == nil {
+			store.AppendResponseBody(s.exchangeID(msg.ID), body)
+		}
+	case ResponseEnd:
+		id := s.exchangeID(msg.ID)
+		store.Finish(id, msg.Error)
+		s.persist(id)
+	}
+}
+
+// persist queues a finished exchange, by inspector ID, for the replay history and capture file
+func (s *session) persist(id string) {
+	if s.opts.persist == nil {
+		return
+	}
+	if ex, ok := s.opts.Inspector.Get(id); ok {
+		s.opts.persist.save(ex)
+	}
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// IPFilter restricts a tunnel to client addresses in allowed ranges. Denied
+// ranges win over allowed ones; with no allowed ranges everything not denied passes
+type IPFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// newIPFilter parses CIDR ranges or single addresses. It returns nil when
+// both lists are empty
+func newIPFilter(allow, deny []string) (*IPFilter, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	f := &IPFilter{}
+	var err error
+	if f.allow, err = parsePrefixes(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = parsePrefixes(deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		for _, item := range splitList(value) {
+			if !strings.Contains(item, "/") {
+				addr, err := netip.ParseAddr(item)
+				if err != nil {
+					return nil, fmt.Errorf("invalid address %q: %w", item, err)
+				}
+				prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+				continue
+			}
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", item, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+		}
+	}
+	return prefixes, nil
+}
+
+// check reports whether a client address may use the tunnel, and why not.
+// Requests without a usable address are only let through when no allowlist is set
+func (f *IPFilter) check(ip string) (bool, string) {
+	if f == nil {
+		return true, ""
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		if len(f.allow) > 0 {
+			return false, "an allowlist is set and the edge sent no client address"
+		}
+		return true, ""
+	}
+	addr = addr.Unmap()
+	for _, prefix := range f.deny {
+		if prefix.Contains(addr) {
+			return false, "in denied range " + prefix.String()
+		}
+	}
+	if len(f.allow) == 0 {
+		return true, ""
+	}
+	for _, prefix := range f.allow {
+		if prefix.Contains(addr) {
+			return true, ""
+		}
+	}
+	return false, "not in allowed ranges"
+}
+
+// clientIP is the address of the visitor: the client_ip reported by the edge,
+// or else the last X-Forwarded-For hop when the edge negotiated that it
+// appends it. Any other header is set by the visitor, so the address is
+// unknown without them
+func (s *session) clientIP(reported string, headers Header) string {
+	if reported != "" {
+		return reported
+	}
+	if !s.forwarded {
+		return ""
+	}
+	if forwarded := splitList(headers.Get("X-Forwarded-For")); len(forwarded) > 0 {
+		return forwarded[len(forwarded)-1]
+	}
+	return ""
+}
+
+// sendForbidden rejects a request from a blocked address without contacting the upstream
+func sendForbidden(s *session, req IncomingRequest, ip, reason string) {
+	log.Printf("⛔ Blocked %s %s from %s: %s", req.Method, req.Path, displayIP(ip), reason)
+	s.printRequest(req.Method, req.Path, http.StatusForbidden, "IP")
+	sendErrorResponse(s, req.ID, "Forbidden", http.StatusForbidden)
+}
+
+func displayIP(ip string) string {
+	if ip == "" {
+		return "unknown address"
+	}
+	return ip
+}
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/panngo/devpipe-cli/inspector"
+)
+
+// Request concurrency defaults. Most dev servers handle a handful of requests
+// at once, so a burst waits in the queue instead of piling onto the app
+const (
+	DefaultMaxConcurrent = 16
+	DefaultMaxQueue      = 64
+	// busyRetryAfter is the Retry-After, in seconds, of requests turned away
+	busyRetryAfter = 1
+)
+
+// requestLimiter caps how many requests of a tunnel reach the app at once.
+// The rest wait in a bounded queue; past that they are turned away with a 503.
+// It outlives reconnects, since requests of an old connection may still run
+type requestLimiter struct {
+	// slots holds a token per running request, nil for no limit
+	slots    chan struct{}
+	maxQueue int64
+
+	// pending counts the admitted requests, running or queued
+	pending  atomic.Int64
+	active   atomic.Int64
+	queued   atomic.Int64
+	rejected atomic.Int64
+}
+
+// newRequestLimiter lets maxConcurrent requests run with maxQueue more
+// waiting. maxConcurrent 0 means no limit, and no queue
+func newRequestLimiter(maxConcurrent, maxQueue int) (*requestLimiter, error) {
+	if maxConcurrent < 0 || maxQueue < 0 {
+		return nil, fmt.Errorf("invalid request limits: max concurrent %d and max queue %d can't be negative", maxConcurrent, maxQueue)
+	}
+	l := &requestLimiter{maxQueue: int64(maxQueue)}
+	if maxConcurrent > 0 {
+		l.slots = make(chan struct{}, maxConcurrent)
+	}
+	return l, nil
+}
+
+// admit reserves a place for a request, running or queued. It is called as
+// the request arrives, so a burst is bounded before any goroutine starts
+func (l *requestLimiter) admit() bool {
+	if l == nil {
+		return true
+	}
+	if l.slots != nil && l.pending.Add(1) > int64(cap(l.slots))+l.maxQueue {
+		l.pending.Add(-1)
+		l.rejected.Add(1)
+		return false
+	}
+	return true
+}
+
+// requestSlot is the place of one admitted request. The request only takes
+// a running slot once it passed the local checks, and gives it back as soon
+// as the app is done with it
+type requestSlot struct {
+	limiter *requestLimiter
+	running bool
+	once    sync.Once
+}
+
+// slot returns the place of a request admitted by admit
+func (l *requestLimiter) slot() *requestSlot {
+	return &requestSlot{limiter: l}
+}
+
+// acquire waits for a free slot for req. It returns false when ctx ends
+// first, e.g. when the visitor goes away while queued
+func (r *requestSlot) acquire(ctx context.Context, req IncomingRequest) bool {
+	l := r.limiter
+	if l == nil {
+		return true
+	}
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			depth := l.queued.Add(1)
+			log.Printf("⏳ Queued %s %s, %d waiting for the app", req.Method, req.Path, depth)
+			select {
+			case l.slots <- struct{}{}:
+				l.queued.Add(-1)
+			case <-ctx.Done():
+				l.queued.Add(-1)
+				return false
+			}
+		}
+	}
+	r.running = true
+	l.active.Add(1)
+	return true
+}
+
+// release gives back the place of the request, and its slot if it got one.
+// Only the first call counts
+func (r *requestSlot) release() {
+	l := r.limiter
+	if l == nil {
+		return
+	}
+	r.once.Do(func() {
+		if r.running {
+			l.active.Add(-1)
+			if l.slots != nil {
+				<-l.slots
+			}
+		}
+		if l.slots != nil {
+			l.pending.Add(-1)
+		}
+	})
+}
+
+// load reports the limiter state to the inspector stats
+func (l *requestLimiter) load(tunnel string) func() inspector.Load {
+	return func() inspector.Load {
+		return inspector.Load{
+			Tunnel:        tunnel,
+			Active:        l.active.Load(),
+			Queued:        l.queued.Load(),
+			Rejected:      l.rejected.Load(),
+			MaxConcurrent: cap(l.slots),
+			MaxQueue:      int(l.maxQueue),
+		}
+	}
+}
+
+// rejectBusy turns a request away when the tunnel is at capacity
+func rejectBusy(s *session, req IncomingRequest) {
+	l := s.opts.Limiter
+	log.Printf("🚦 Rejected %s %s: %d requests running or queued, %d rejected so far", req.Method, req.Path, l.pending.Load(), l.rejected.Load())
+	response := OutgoingResponse{
+		ID:     req.ID,
+		Status: http.StatusServiceUnavailable,
+		Headers: Header{
+			"Content-Type": {"text/plain"},
+			"Retry-After":  {strconv.Itoa(busyRetryAfter)},
+		},
+		Body: "Tunnel is busy, try again shortly",
+	}
+	s.printRequest(req.Method, req.Path, response.Status, "BUSY")
+
+	if err := s.send(response); err != nil {
+		log.Printf("❌ Error sending error response: %v", err)
+	}
+}
@@ -0,0 +1,561 @@
+package client
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/panngo/devpipe-cli/config"
+)
+
+// Login providers
+const (
+	OAuthGoogle = "google"
+	OAuthGitHub = "github"
+	OAuthOIDC   = "oidc"
+)
+
+const (
+	// oauthCallbackPath is answered by devpipe and must be registered with the
+	// provider as https://<tunnel>.devpipe.cloud/_devpipe/oauth/callback
+	oauthCallbackPath  = "/_devpipe/oauth/callback"
+	oauthLogoutPath    = "/_devpipe/oauth/logout"
+	oauthSessionCookie = "devpipe_session"
+	oauthStateCookie   = "devpipe_oauth_state"
+	oauthSessionTTL    = 24 * time.Hour
+	oauthStateTTL      = 10 * time.Minute
+	// oauthEmailHeader tells the app who logged in
+	oauthEmailHeader = "X-Forwarded-Email"
+)
+
+// oauthEndpoints are the provider URLs, as published by OIDC discovery
+type oauthEndpoints struct {
+	Authorization string `json:"authorization_endpoint"`
+	Token         string `json:"token_endpoint"`
+	UserInfo      string `json:"userinfo_endpoint"`
+}
+
+var githubEndpoints = oauthEndpoints{
+	Authorization: "https://github.com/login/oauth/authorize",
+	Token:         "https://github.com/login/oauth/access_token",
+	UserInfo:      "https://api.github.com/user/emails",
+}
+
+// OAuthGate requires visitors to log in with an OAuth/OIDC provider before
+// their requests reach the upstream. Logged in visitors carry a signed
+// session cookie
+type OAuthGate struct {
+	provider     string
+	clientID     string
+	clientSecret string
+	endpoints    oauthEndpoints
+	scopes       string
+	domains      []string
+	emails       []string
+	key          []byte
+	// id identifies the login settings, see gateID
+	id     string
+	client *http.Client
+}
+
+// oauthSession is the payload of the session cookie. Every tunnel signs with
+// the same key, so a session is only valid on the tunnel and with the login
+// settings it was issued for
+type oauthSession struct {
+	Email   string `json:"email"`
+	Tunnel  string `json:"tunnel"`
+	Gate    string `json:"gate"`
+	Expires int64  `json:"exp"`
+}
+
+// errUnverifiedEmail means the provider vouches for no email we can allow
+var errUnverifiedEmail = errors.New("account has no verified email")
+
+// oauthState ties a callback to the login it started, and remembers where to return
+type oauthState struct {
+	State   string `json:"state"`
+	Return  string `json:"return"`
+	Expires int64  `json:"exp"`
+}
+
+// newOAuthGate validates the login settings and looks up the provider
+// endpoints. It returns nil when no provider is set. The client secret can
+// also come from DEVPIPE_OAUTH_CLIENT_SECRET, to keep it out of tunnel files
+func newOAuthGate(spec config.OAuthSpec, configManager *config.ConfigManager) (*OAuthGate, error) {
+	if spec.Provider == "" {
+		return nil, nil
+	}
+	if spec.ClientSecret == "" {
+		spec.ClientSecret = os.Getenv("DEVPIPE_OAUTH_CLIENT_SECRET")
+	}
+	if spec.ClientID == "" || spec.ClientSecret == "" {
+		return nil, errors.New("login needs an OAuth client ID and secret")
+	}
+	if len(spec.AllowDomains) == 0 && len(spec.AllowEmails) == 0 {
+		return nil, errors.New("login needs allowed email domains or addresses, or anyone with an account could get in")
+	}
+
+	// Sessions are signed with a key kept on disk, so restarts don't log everyone out
+	key, err := configManager.Secret("oauth.key")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the session key: %w", err)
+	}
+
+	g := &OAuthGate{
+		provider:     spec.Provider,
+		clientID:     spec.ClientID,
+		clientSecret: spec.ClientSecret,
+		scopes:       "openid email profile",
+		key:          key,
+		id:           gateID(spec),
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, domain := range spec.AllowDomains {
+		g.domains = append(g.domains, strings.ToLower(strings.TrimPrefix(domain, "@")))
+	}
+	for _, email := range spec.AllowEmails {
+		g.emails = append(g.emails, strings.ToLower(email))
+	}
+
+	switch spec.Provider {
+	case OAuthGoogle:
+		g.endpoints, err = g.discover("https://accounts.google.com")
+	case OAuthGitHub:
+		g.endpoints = githubEndpoints
+		g.scopes = "read:user user:email"
+	case OAuthOIDC:
+		if spec.Issuer == "" {
+			return nil, errors.New("OIDC login needs an issuer URL")
+		}
+		g.endpoints, err = g.discover(spec.Issuer)
+	default:
+		return nil, fmt.Errorf("invalid login provider %q: use %s, %s or %s", spec.Provider, OAuthGoogle, OAuthGitHub, OAuthOIDC)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// gateID identifies a provider, client and allowlist. Sessions carry it, so
+// narrowing the allowlist or switching providers logs everyone out
+func gateID(spec config.OAuthSpec) string {
+	domains := make([]string, 0, len(spec.AllowDomains))
+	for _, domain := range spec.AllowDomains {
+		domains = append(domains, strings.ToLower(strings.TrimPrefix(domain, "@")))
+	}
+	emails := make([]string, 0, len(spec.AllowEmails))
+	for _, email := range spec.AllowEmails {
+		emails = append(emails, strings.ToLower(email))
+	}
+	sort.Strings(domains)
+	sort.Strings(emails)
+
+	h := sha256.New()
+	for _, part := range []string{spec.Provider, spec.Issuer, spec.ClientID, strings.Join(domains, ","), strings.Join(emails, ",")} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// discover reads the OIDC discovery document of an issuer
+func (g *OAuthGate) discover(issuer string) (oauthEndpoints, error) {
+	var endpoints oauthEndpoints
+	resp, err := g.client.Get(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return endpoints, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return endpoints, fmt.Errorf("OIDC discovery failed: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&endpoints); err != nil {
+		return endpoints, fmt.Errorf("invalid OIDC discovery document: %w", err)
+	}
+	if endpoints.Authorization == "" || endpoints.Token == "" || endpoints.UserInfo == "" {
+		return endpoints, errors.New("OIDC discovery document lacks authorization, token or userinfo endpoints")
+	}
+	return endpoints, nil
+}
+
+// gate checks a request against the login. It returns the headers to forward,
+// or false when it answered the request itself: the callback, a logout, a
+// redirect to the provider or a 401
+func (g *OAuthGate) gate(s *session, req IncomingRequest) (Header, bool) {
+	if g == nil {
+		return req.Headers, true
+	}
+	path, _, _ := strings.Cut(req.Path, "?")
+	switch path {
+	case oauthCallbackPath:
+		g.callback(s, req)
+		return nil, false
+	case oauthLogoutPath:
+		g.respond(s, req, http.StatusFound, Header{
+			"Location":   {"/"},
+			"Set-Cookie": {expiredCookie(oauthSessionCookie)},
+		}, "")
+		return nil, false
+	}
+
+	if headers, ok := g.check(s, req.Headers); ok {
+		return headers, true
+	}
+
+	// Browsers navigating to a page are sent to log in; API calls just get a 401
+	if req.Method == "GET" && strings.Contains(req.Headers.Get("Accept"), "text/html") {
+		g.startLogin(s, req)
+	} else {
+		log.Printf("🔒 Rejected %s %s: not logged in", req.Method, req.Path)
+		g.respond(s, req, http.StatusUnauthorized, Header{"Content-Type": {"text/plain"}}, "Login required")
+	}
+	return nil, false
+}
+
+// check returns the headers to forward for a visitor with a valid session.
+// WebSockets can't be redirected to log in, so they only get this check
+func (g *OAuthGate) check(s *session, headers Header) (Header, bool) {
+	if g == nil {
+		return headers, true
+	}
+	email, ok := g.sessionEmail(s.conn.TunnelID, headers)
+	if !ok {
+		return nil, false
+	}
+	return g.forwardHeaders(headers, email), true
+}
+
+// sessionEmail returns the email of a session cookie valid on the tunnel
+func (g *OAuthGate) sessionEmail(tunnelID string, headers Header) (string, bool) {
+	cookie := findCookie(headers, oauthSessionCookie)
+	if cookie == "" {
+		return "", false
+	}
+	var session oauthSession
+	if !g.verify(cookie, &session) || time.Now().Unix() > session.Expires ||
+		session.Tunnel != tunnelID || session.Gate != g.id {
+		return "", false
+	}
+	return session.Email, true
+}
+
+// forwardHeaders removes the gate cookies and tells the app who is logged in.
+// Any X-Forwarded-Email sent by the visitor is replaced
+func (g *OAuthGate) forwardHeaders(headers Header, email string) Header {
+	forwarded := headers.clone()
+	dropCookies(forwarded, oauthSessionCookie, oauthStateCookie)
+	forwarded.Set(oauthEmailHeader, email)
+	return forwarded
+}
+
+// redact removes the gate cookies from a request as it is kept by the
+// inspector, history and captures
+func (g *OAuthGate) redact(headers Header) Header {
+	if g == nil {
+		return headers
+	}
+	headers = headers.clone()
+	dropCookies(headers, oauthSessionCookie, oauthStateCookie)
+	return headers
+}
+
+// startLogin redirects the browser to the provider, remembering the page it asked for
+func (g *OAuthGate) startLogin(s *session, req IncomingRequest) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		g.respond(s, req, http.StatusInternalServerError, Header{"Content-Type": {"text/plain"}}, "Login failed")
+		return
+	}
+	state := oauthState{
+		State:   hex.EncodeToString(nonce),
+		Return:  req.Path,
+		Expires: time.Now().Add(oauthStateTTL).Unix(),
+	}
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {g.clientID},
+		"redirect_uri":  {redirectURI(req.Headers)},
+		"scope":         {g.scopes},
+		"state":         {state.State},
+	}
+	g.respond(s, req, http.StatusFound, Header{
+		"Location":   {g.endpoints.Authorization + "?" + query.Encode()},
+		"Set-Cookie": {g.cookie(oauthStateCookie, g.sign(state), oauthStateTTL)},
+	}, "")
+}
+
+// callback finishes a login: it checks the state, exchanges the code and
+// starts a session if the email is allowed
+func (g *OAuthGate) callback(s *session, req IncomingRequest) {
+	fail := func(status int, reason string) {
+		log.Printf("🔒 Login failed: %s", reason)
+		g.respond(s, req, status, Header{
+			"Content-Type": {"text/plain"},
+			"Set-Cookie":   {expiredCookie(oauthStateCookie)},
+		}, "Login failed: "+reason)
+	}
+
+	u, err := url.Parse(req.Path)
+	if err != nil {
+		fail(http.StatusBadRequest, "invalid callback URL")
+		return
+	}
+	query := u.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		fail(http.StatusForbidden, providerError)
+		return
+	}
+
+	var state oauthState
+	if !g.verify(findCookie(req.Headers, oauthStateCookie), &state) ||
+		time.Now().Unix() > state.Expires ||
+		!hmac.Equal([]byte(state.State), []byte(query.Get("state"))) {
+		fail(http.StatusBadRequest, "invalid or expired login state")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	token, err := g.exchange(ctx, query.Get("code"), redirectURI(req.Headers))
+	if err != nil {
+		fail(http.StatusBadGateway, err.Error())
+		return
+	}
+	email, err := g.fetchEmail(ctx, token)
+	if errors.Is(err, errUnverifiedEmail) {
+		fail(http.StatusForbidden, err.Error())
+		return
+	} else if err != nil {
+		fail(http.StatusBadGateway, err.Error())
+		return
+	}
+	if !g.allowed(email) {
+		fail(http.StatusForbidden, email+" is not allowed")
+		return
+	}
+
+	log.Printf("🔓 Logged in %s", email)
+	session := g.session(s.conn.TunnelID, email)
+	returnTo := state.Return
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+		returnTo = "/"
+	}
+	g.respond(s, req, http.StatusFound, Header{
+		"Location": {returnTo},
+		"Set-Cookie": {
+			g.cookie(oauthSessionCookie, g.sign(session), oauthSessionTTL),
+			expiredCookie(oauthStateCookie),
+		},
+	}, "")
+}
+
+// session starts a session for email on the tunnel
+func (g *OAuthGate) session(tunnelID, email string) oauthSession {
+	return oauthSession{
+		Email:   email,
+		Tunnel:  tunnelID,
+		Gate:    g.id,
+		Expires: time.Now().Add(oauthSessionTTL).Unix(),
+	}
+}
+
+// exchange trades an authorization code for an access token
+func (g *OAuthGate) exchange(ctx context.Context, code, redirectURI string) (string, error) {
+	if code == "" {
+		return "", errors.New("missing authorization code")
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {g.clientID},
+		"client_secret": {g.clientSecret},
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", g.endpoints.Token, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := g.getJSON(httpReq, &token); err != nil {
+		return "", fmt.Errorf("code exchange failed: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("code exchange failed: %s", strings.TrimSpace("no access token "+token.Error))
+	}
+	return token.AccessToken, nil
+}
+
+// fetchEmail returns the verified email of the logged in user
+func (g *OAuthGate) fetchEmail(ctx context.Context, token string) (string, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", g.endpoints.UserInfo, nil)
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	httpReq.Header.Set("Accept", "application/json")
+
+	if g.provider == OAuthGitHub {
+		var emails []struct {
+			Email    string `json:"email"`
+			Primary  bool   `json:"primary"`
+			Verified bool   `json:"verified"`
+		}
+		if err := g.getJSON(httpReq, &emails); err != nil {
+			return "", fmt.Errorf("failed to read GitHub emails: %w", err)
+		}
+		for _, e := range emails {
+			if e.Primary && e.Verified {
+				return strings.ToLower(e.Email), nil
+			}
+		}
+		return "", errUnverifiedEmail
+	}
+
+	var info struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := g.getJSON(httpReq, &info); err != nil {
+		return "", fmt.Errorf("failed to read user info: %w", err)
+	}
+	if info.Email == "" || !info.EmailVerified {
+		return "", errUnverifiedEmail
+	}
+	return strings.ToLower(info.Email), nil
+}
+
+func (g *OAuthGate) getJSON(req *http.Request, v interface{}) error {
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+func (g *OAuthGate) allowed(email string) bool {
+	for _, allowed := range g.emails {
+		if email == allowed {
+			return true
+		}
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	for _, allowed := range g.domains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// sign encodes v as a cookie value with an HMAC, so visitors can't forge it
+func (g *OAuthGate) sign(v interface{}) string {
+	payload, _ := json.Marshal(v)
+	mac := hmac.New(sha256.New, g.key)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks a signed cookie value and decodes it into v
+func (g *OAuthGate) verify(value string, v interface{}) bool {
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+	given, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, g.key)
+	mac.Write(payload)
+	if !hmac.Equal(given, mac.Sum(nil)) {
+		return false
+	}
+	return json.Unmarshal(payload, v) == nil
+}
+
+func (g *OAuthGate) cookie(name, value string, ttl time.Duration) string {
+	return (&http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}).String()
+}
+
+func (g *OAuthGate) respond(s *session, req IncomingRequest, status int, headers Header, body string) {
+	headers.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	response := OutgoingResponse{ID: req.ID, Status: status, Headers: headers, Body: body}
+	s.printRequest(req.Method, req.Path, status, "LOGIN")
+
+	if err := s.send(response); err != nil {
+		log.Printf("❌ Error sending login response: %v", err)
+	}
+}
+
+// redirectURI is the public callback URL, built from the host the visitor used
+func redirectURI(headers Header) string {
+	proto := "https"
+	if forwarded := splitList(headers.Get("X-Forwarded-Proto")); len(forwarded) > 0 {
+		proto = forwarded[0]
+	}
+	return proto + "://" + publicHost(headers) + oauthCallbackPath
+}
+
+// publicHost is the tunnel host the visitor used
+func publicHost(headers Header) string {
+	if forwarded := splitList(headers.Get("X-Forwarded-Host")); len(forwarded) > 0 {
+		return strings.ToLower(forwarded[0])
+	}
+	return strings.ToLower(headers.Get("Host"))
+}
+
+func findCookie(headers Header, name string) string {
+	for _, cookie := range (&http.Request{Header: http.Header{"Cookie": cookieValues(headers)}}).Cookies() {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func expiredCookie(name string) string {
+	return (&http.Cookie{Name: name, Value: "", Path: "/", MaxAge: -1, HttpOnly: true, Secure: true}).String()
+}
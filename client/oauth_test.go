@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/panngo/devpipe-cli/config"
+)
+
+// newTestProvider serves OIDC discovery, token and userinfo endpoints that
+// accept one code and vouch for email
+func newTestProvider(t *testing.T, email string, verified bool) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"userinfo_endpoint":      srv.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.PostFormValue("code") != "good-code" ||
+			r.PostFormValue("client_id") != "client" || r.PostFormValue("client_secret") != "secret" ||
+			r.PostFormValue("redirect_uri") != "https://t1.example.com"+oauthCallbackPath {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "token", "token_type": "Bearer"})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"email": email, "email_verified": verified})
+	})
+	return srv
+}
+
+func newTestGate(t *testing.T, spec config.OAuthSpec) *OAuthGate {
+	t.Helper()
+	g, err := newOAuthGate(spec, config.NewConfigManager())
+	if err != nil {
+		t.Fatalf("newOAuthGate: %v", err)
+	}
+	return g
+}
+
+func TestOAuthGateRoundTrip(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	provider := newTestProvider(t, "Ana@Example.com", true)
+	spec := config.OAuthSpec{
+		Provider:     OAuthOIDC,
+		Issuer:       provider.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		AllowDomains: []string{"example.com"},
+	}
+	g := newTestGate(t, spec)
+	if g.endpoints.Token != provider.URL+"/token" {
+		t.Fatalf("discovered token endpoint %q", g.endpoints.Token)
+	}
+
+	ctx := context.Background()
+	redirect := "https://t1.example.com" + oauthCallbackPath
+	if _, err := g.exchange(ctx, "bad-code", redirect); err == nil {
+		t.Fatal("exchange accepted a bad code")
+	}
+	token, err := g.exchange(ctx, "good-code", redirect)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	email, err := g.fetchEmail(ctx, token)
+	if err != nil {
+		t.Fatalf("fetchEmail: %v", err)
+	}
+	if email != "ana@example.com" || !g.allowed(email) {
+		t.Fatalf("email %q should be allowed", email)
+	}
+
+	cookie := Header{"Cookie": {oauthSessionCookie + "=" + g.sign(g.session("t1", email))}}
+	if got, ok := g.sessionEmail("t1", cookie); !ok || got != email {
+		t.Fatalf("session on its own tunnel = %q, %v", got, ok)
+	}
+	if _, ok := g.sessionEmail("t2", cookie); ok {
+		t.Fatal("session accepted on another tunnel")
+	}
+
+	// Same signing key, narrower allowlist
+	spec.AllowDomains = nil
+	spec.AllowEmails = []string{"bob@example.com"}
+	if _, ok := newTestGate(t, spec).sessionEmail("t1", cookie); ok {
+		t.Fatal("session accepted by a gate with another allowlist")
+	}
+}
+
+func TestOAuthGateUnverifiedEmail(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	provider := newTestProvider(t, "ana@example.com", false)
+	g := newTestGate(t, config.OAuthSpec{
+		Provider:     OAuthOIDC,
+		Issuer:       provider.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		AllowEmails:  []string{"ana@example.com"},
+	})
+	if _, err := g.fetchEmail(context.Background(), "token"); err != errUnverifiedEmail {
+		t.Fatalf("fetchEmail = %v, want errUnverifiedEmail", err)
+	}
+}
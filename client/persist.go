@@ -0,0 +1,92 @@
+package client
+
+import (
+	"log"
+	"sync"
+
+	"github.com/panngo/devpipe-cli/capture"
+	"github.com/panngo/devpipe-cli/inspector"
+	"github.com/panngo/devpipe-cli/replay"
+)
+
+// persistQueueSize is how many finished exchanges may wait for the disk
+const persistQueueSize = 256
+
+// persister saves finished exchanges to the replay history and capture file
+// from a single goroutine, so they are written in the order they finished
+// and disk writes never hold up responses
+type persister struct {
+	history  *replay.Store
+	recorder *capture.Recorder
+	queue    chan inspector.Exchange
+	done     chan struct{}
+
+	// mu guards closed, so nothing is queued once the writer stops
+	mu     sync.RWMutex
+	closed bool
+}
+
+// newPersister starts the writer. It returns nil when there is nothing to save to
+func newPersister(history *replay.Store, recorder *capture.Recorder) *persister {
+	if history == nil && recorder == nil {
+		return nil
+	}
+	p := &persister{
+		history:  history,
+		recorder: recorder,
+		queue:    make(chan inspector.Exchange, persistQueueSize),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// save queues a finished exchange
+func (p *persister) save(ex inspector.Exchange) {
+	if p == nil {
+		return
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if !p.closed {
+		p.queue <- ex
+	}
+}
+
+func (p *persister) run() {
+	defer close(p.done)
+	for ex := range p.queue {
+		if p.history != nil {
+			if err := p.history.Save(ex.Record()); err != nil {
+				log.Printf("⚠️  Warning: Could not save request for replay: %v", err)
+			}
+		}
+		if p.recorder != nil {
+			if err := p.recorder.Write(capture.FromExchange(ex)); err != nil {
+				log.Printf("⚠️  Warning: Could not record request: %v", err)
+			}
+		}
+	}
+}
+
+// close writes the exchanges still queued and closes the capture file
+func (p *persister) close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.queue)
+	p.mu.Unlock()
+
+	<-p.done
+	if p.recorder != nil {
+		if err := p.recorder.Close(); err != nil {
+			log.Printf("⚠️  Warning: Could not close capture file: %v", err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/panngo/devpipe-cli/config"
+	"github.com/panngo/devpipe-cli/ui"
+)
+
+// Reconnect defaults: retry forever, from 1s up to 30s between attempts
+const (
+	DefaultReconnectAttempts = 0
+	DefaultReconnectDelay    = time.Second
+	DefaultReconnectMaxDelay = 30 * time.Second
+	DefaultReconnectJitter   = 0.2
+)
+
+// ReconnectPolicy is how a dropped tunnel gets back online. A laptop waking
+// up or a server deploy can take a while, so by default it never gives up
+type ReconnectPolicy struct {
+	// MaxAttempts gives up after this many failed attempts, 0 retries forever
+	MaxAttempts int
+	// BaseDelay is the first wait, doubled after each failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter shifts each wait by up to this fraction, so tunnels dropped by the
+	// same deploy don't all come back at the same instant
+	Jitter float64
+}
+
+// reconnectFlags registers the reconnect policy flags on fs
+func reconnectFlags(fs *flag.FlagSet) *ReconnectPolicy {
+	policy := &ReconnectPolicy{}
+	fs.IntVar(&policy.MaxAttempts, "reconnect-attempts", DefaultReconnectAttempts, "Give up reconnecting after this many failed attempts (0 retries forever)")
+	fs.DurationVar(&policy.BaseDelay, "reconnect-delay", DefaultReconnectDelay, "Wait before the first reconnect attempt, doubled after each failure")
+	fs.DurationVar(&policy.MaxDelay, "reconnect-max-delay", DefaultReconnectMaxDelay, "Longest wait between reconnect attempts")
+	fs.Float64Var(&policy.Jitter, "reconnect-jitter", DefaultReconnectJitter, "Randomize reconnect waits by up to this fraction (0 to 1)")
+	return policy
+}
+
+// resolveReconnectPolicy fills the reconnect flags left unset from the
+// settings file, so flags win over ~/.devpipe/config.json
+func resolveReconnectPolicy(fs *flag.FlagSet, policy ReconnectPolicy, configManager *config.ConfigManager) (ReconnectPolicy, error) {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	settings, err := configManager.LoadSettings()
+	if err != nil {
+		log.Printf("⚠️  Warning: Could not load settings: %v", err)
+		settings = &config.Settings{}
+	}
+	saved := settings.Reconnect
+	if saved.MaxAttempts != nil && !set["reconnect-attempts"] {
+		policy.MaxAttempts = *saved.MaxAttempts
+	}
+	if saved.BaseDelay != "" && !set["reconnect-delay"] {
+		if policy.BaseDelay, err = time.ParseDuration(saved.BaseDelay); err != nil {
+			return policy, fmt.Errorf("invalid reconnect base_delay in settings: %w", err)
+		}
+	}
+	if saved.MaxDelay != "" && !set["reconnect-max-delay"] {
+		if policy.MaxDelay, err = time.ParseDuration(saved.MaxDelay); err != nil {
+			return policy, fmt.Errorf("invalid reconnect max_delay in settings: %w", err)
+		}
+	}
+	if saved.Jitter != nil && !set["reconnect-jitter"] {
+		policy.Jitter = *saved.Jitter
+	}
+	return policy, policy.validate()
+}
+
+func (p ReconnectPolicy) validate() error {
+	switch {
+	case p.MaxAttempts < 0:
+		return fmt.Errorf("invalid reconnect attempts %d, use 0 to retry forever", p.MaxAttempts)
+	case p.BaseDelay <= 0 || p.MaxDelay <= 0:
+		return fmt.Errorf("invalid reconnect delays %v and %v, they must be positive", p.BaseDelay, p.MaxDelay)
+	case p.BaseDelay > p.MaxDelay:
+		return fmt.Errorf("reconnect delay %v is longer than the max delay %v", p.BaseDelay, p.MaxDelay)
+	case p.Jitter < 0 || p.Jitter > 1:
+		return fmt.Errorf("invalid reconnect jitter %v, it must be between 0 and 1", p.Jitter)
+	}
+	return nil
+}
+
+// delay is the wait after the given failed attempt, counting from 1
+func (p ReconnectPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return time.Duration(float64(d) * (1 + p.Jitter*(2*rand.Float64()-1)))
+}
+
+// exhausted reports whether no attempt is left after the given one
+func (p ReconnectPolicy) exhausted(attempt int) bool {
+	return p.MaxAttempts > 0 && attempt >= p.MaxAttempts
+}
+
+// waitToReconnect sleeps for d with a live countdown. The wait ends early
+// when the network changes, since the server is likely reachable again,
+// and returns false when ctx is canceled
+func waitToReconnect(ctx context.Context, name string, attempt int, d time.Duration) bool {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	defer ui.ClearStatus()
+
+	network := networkFingerprint()
+	deadline := time.Now().Add(d)
+	for {
+		ui.PrintReconnectCountdown(name, attempt+1, time.Until(deadline))
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case <-ticker.C:
+			if current := networkFingerprint(); current != network {
+				ui.ClearStatus()
+				log.Println("🌐 Network changed, reconnecting now")
+				return true
+			}
+		}
+	}
+}
+
+// networkFingerprint lists the addresses of the interfaces that are up,
+// loopback aside. Joining a network or waking from sleep changes it
+func networkFingerprint() string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	var addrs []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range ifaceAddrs {
+			addrs = append(addrs, iface.Name+"="+addr.String())
+		}
+	}
+	sort.Strings(addrs)
+	return strings.Join(addrs, ",")
+}
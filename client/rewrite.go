@@ -0,0 +1,117 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/panngo/devpipe-cli/config"
+	"github.com/panngo/devpipe-cli/ui"
+)
+
+// placeholderPattern finds the {name} placeholders of a header rule value
+var placeholderPattern = regexp.MustCompile(`\{[a-z_]+\}`)
+
+// headerPlaceholders are the values header rules can refer to
+var headerPlaceholders = map[string]bool{
+	"{tunnel_url}":  true,
+	"{tunnel_host}": true,
+	"{client_ip}":   true,
+}
+
+// HeaderRules rewrite request or response headers, in order
+type HeaderRules []config.HeaderRuleSpec
+
+// newHeaderRules validates header rules and their placeholders
+func newHeaderRules(specs []config.HeaderRuleSpec) (HeaderRules, error) {
+	for _, spec := range specs {
+		if err := spec.Validate(); err != nil {
+			return nil, err
+		}
+		for _, placeholder := range placeholderPattern.FindAllString(spec.Value, -1) {
+			if !headerPlaceholders[placeholder] {
+				return nil, fmt.Errorf("header rule %s %s: unknown placeholder %s, use {tunnel_url}, {tunnel_host} or {client_ip}", spec.Action, spec.Name, placeholder)
+			}
+		}
+	}
+	return HeaderRules(specs), nil
+}
+
+// apply runs the rules against h. Values that come out empty, like
+// {client_ip} when the edge doesn't report it, are not written
+func (r HeaderRules) apply(h http.Header, values *strings.Replacer) {
+	for _, rule := range r {
+		value := values.Replace(rule.Value)
+		if rule.Action != config.HeaderRemove && value == "" && rule.Value != "" {
+			continue
+		}
+		switch rule.Action {
+		case config.HeaderSet:
+			h.Set(rule.Name, value)
+		case config.HeaderAdd:
+			h.Add(rule.Name, value)
+		case config.HeaderRemove:
+			h.Del(rule.Name)
+		}
+	}
+}
+
+// headerValues fills in the placeholders of header rules for a request. The
+// tunnel URL is the one printed in the banner
+func (s *session) headerValues(clientIP string) *strings.Replacer {
+	tunnelURL := ui.TunnelURL(s.opts.ServerURL, s.conn.TunnelID)
+	return strings.NewReplacer(
+		"{tunnel_url}", tunnelURL,
+		"{tunnel_host}", strings.TrimPrefix(tunnelURL, "https://"),
+		"{client_ip}", clientIP,
+	)
+}
+
+// rewriteRequest applies the request header rules to a local request. A
+// rule for Host changes the host the upstream sees
+func (s *session) rewriteRequest(httpReq *http.Request, values *strings.Replacer) {
+	if len(s.opts.RequestHeaders) == 0 {
+		return
+	}
+	s.opts.RequestHeaders.apply(httpReq.Header, values)
+	if host := httpReq.Header.Get("Host"); host != "" {
+		httpReq.Host = host
+		httpReq.Header.Del("Host")
+	}
+}
+
+// rewriteResponse applies the response header rules to an app response
+func (s *session) rewriteResponse(header http.Header, values *strings.Replacer) {
+	if len(s.opts.ResponseHeaders) > 0 {
+		s.opts.ResponseHeaders.apply(header, values)
+	}
+}
+
+// rewriteWebSocket applies the request header rules to a WebSocket dial.
+// The dialer takes a Host header as the host to send
+func (s *session) rewriteWebSocket(header http.Header, values *strings.Replacer) {
+	if len(s.opts.RequestHeaders) > 0 {
+		s.opts.RequestHeaders.apply(header, values)
+	}
+}
+
+// headerRuleList collects repeated -request-header or -response-header flags
+type headerRuleList []config.HeaderRuleSpec
+
+func (l *headerRuleList) String() string {
+	rules := make([]string, len(*l))
+	for i, rule := range *l {
+		rules[i] = rule.Action + " " + rule.Name
+	}
+	return strings.Join(rules, ", ")
+}
+
+func (l *headerRuleList) Set(value string) error {
+	rule, err := config.ParseHeaderRule(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, rule)
+	return nil
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/panngo/devpipe-cli/config"
+)
+
+// Route sends the requests whose path matches it to its own upstream
+type Route struct {
+	Pattern  string
+	Upstream *Upstream
+	strip    bool
+	prefix   string
+	exact    bool
+}
+
+// newRoutes builds the routing table from route specs, longest pattern first
+// so the most specific rule wins. Upstreams given as a port or :port are on
+// localhost, and share the TLS and connection settings of the tunnel
+func newRoutes(specs []config.RouteSpec, opts UpstreamOptions) ([]Route, error) {
+	routes := make([]Route, 0, len(specs))
+	for _, spec := range specs {
+		target := spec.Upstream
+		if port := strings.TrimPrefix(target, ":"); isPort(port) {
+			target = localUpstream(port)
+		}
+		upstream, err := NewUpstream(target, opts)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", spec.Path, err)
+		}
+
+		route := Route{Pattern: spec.Path, Upstream: upstream, strip: spec.StripPrefix}
+		if prefix, ok := strings.CutSuffix(spec.Path, "*"); ok {
+			route.prefix = prefix
+		} else {
+			route.prefix = spec.Path
+			route.exact = true
+		}
+		routes = append(routes, route)
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].prefix) > len(routes[j].prefix)
+	})
+	return routes, nil
+}
+
+// match reports whether a request path (without query) is covered by the route.
+// /api/* also matches /api itself
+func (r Route) match(path string) bool {
+	if r.exact {
+		return path == r.prefix
+	}
+	return strings.HasPrefix(path, r.prefix) || path+"/" == r.prefix
+}
+
+// rewrite strips the route prefix from a request path and query, if asked to
+func (r Route) rewrite(requestPath string) string {
+	if !r.strip {
+		return requestPath
+	}
+	prefix := strings.TrimSuffix(r.prefix, "/")
+	rest := strings.TrimPrefix(requestPath, prefix)
+	if rest == "" || rest[0] != '/' {
+		rest = "/" + rest
+	}
+	return rest
+}
+
+// route picks the upstream for a request path and the path to send it with
+func (s *session) route(requestPath string) (*Upstream, string) {
+	path, _, _ := strings.Cut(requestPath, "?")
+	for _, route := range s.opts.Routes {
+		if route.match(path) {
+			return route.Upstream, route.rewrite(requestPath)
+		}
+	}
+	return s.upstream, requestPath
+}
+
+func isPort(value string) bool {
+	if value == "" || len(value) > 5 {
+		return false
+	}
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,209 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/panngo/devpipe-cli/ws"
+)
+
+// session holds the state shared by the request handlers of one connection
+type session struct {
+	conn      *ws.SafeConn
+	opts      Options
+	upstream  *Upstream
+	protocol  int
+	streaming bool
+	uploading bool
+	websocket bool
+	base64    bool
+	// forwarded means the edge appends the visitor address to X-Forwarded-For
+	forwarded bool
+	streams   *frameScheduler
+	uploads   *uploadRegistry
+	relays    *wsRelayRegistry
+	requests  *requestContexts
+
+	// mu guards draining, so no handler starts once a shutdown waits on inflight
+	mu       sync.Mutex
+	draining bool
+	inflight sync.WaitGroup
+}
+
+func newSession(conn *ws.SafeConn, opts Options) *session {
+	s := &session{
+		conn:      conn,
+		opts:      opts,
+		upstream:  opts.Upstream,
+		protocol:  conn.Protocol,
+		streaming: conn.HasCapability(ws.CapabilityStreaming),
+		uploading: conn.HasCapability(ws.CapabilityRequestStreaming),
+		websocket: conn.HasCapability(ws.CapabilityWebSocket),
+		base64:    conn.HasCapability(ws.CapabilityBase64Body),
+		forwarded: conn.HasCapability(ws.CapabilityForwardedFor),
+		relays:    newWSRelayRegistry(),
+		requests:  newRequestContexts(),
+	}
+	s.streams = newFrameScheduler(s.send)
+	s.uploads = newUploadRegistry(s.send, s.observeUpload)
+	return s
+}
+
+// printRequest prints a request line under the banner, prefixed with the
+// tunnel name when several tunnels share the terminal
+func (s *session) printRequest(method, path string, status int, result string) {
+	// One Printf per line, so concurrent tunnels don't interleave their output
+	if s.opts.Name != "" {
+		fmt.Printf("%-12s %-6s %-20s %d %s\n", s.opts.Name, method, path, status, result)
+		return
+	}
+	fmt.Printf("%-6s %-20s %d %s\n", method, path, status, result)
+}
+
+// send writes a message to the server in the form its protocol version expects
+func (s *session) send(v interface{}) error {
+	s.observe(v)
+	return s.conn.WriteJSON(s.wire(v))
+}
+
+// encodeBody prepares a response body for the server. Servers without
+// base64 support get the raw bytes as a string, as before body_encoding existed
+func (s *session) encodeBody(body []byte, header http.Header) (string, string) {
+	if !s.base64 {
+		return string(body), ""
+	}
+	return encodeBody(body, header.Get("Content-Type"), header.Get("Content-Encoding"))
+}
+
+// wire flattens the headers of outgoing messages for protocol 1 servers.
+// The outer Headers field shadows the embedded one when encoded
+func (s *session) wire(v interface{}) interface{} {
+	if s.protocol >= 2 {
+		return v
+	}
+	switch msg := v.(type) {
+	case OutgoingResponse:
+		return struct {
+			OutgoingResponse
+			Headers map[string]string `json:"headers"`
+		}{msg, msg.Headers.flatten()}
+	case ResponseStart:
+		return struct {
+			ResponseStart
+			Headers map[string]string `json:"headers"`
+		}{msg, msg.Headers.flatten()}
+	case WebSocketOpened:
+		return struct {
+			WebSocketOpened
+			Headers map[string]string `json:"headers,omitempty"`
+		}{msg, msg.Headers.flatten()}
+	}
+	return v
+}
+
+// close aborts any requests, responses, uploads and WebSockets still open on this connection
+func (s *session) close() {
+	s.requests.cancelAll(errSessionClosed)
+	s.streams.close()
+	s.uploads.closeAll()
+	s.relays.closeAll()
+}
+
+// dispatch routes a server message by its type. Messages without a type are
+// plain requests, as sent by servers that predate typed frames
+func (s *session) dispatch(msg []byte) {
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(msg, &envelope); err != nil {
+		log.Println("❌ JSON unmarshal error:", err)
+		return
+	}
+
+	switch envelope.Type {
+	case "", "request":
+		var req IncomingRequest
+		if err := json.Unmarshal(msg, &req); err != nil {
+			log.Println("❌ JSON unmarshal error:", err)
+			return
+		}
+		s.observeRequest(req)
+		if !s.track() {
+			sendErrorResponse(s, req.ID, "Tunnel is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		// A full queue is answered right away, before the body or a goroutine
+		if !s.opts.Limiter.admit() {
+			s.inflight.Done()
+			rejectBusy(s, req)
+			return
+		}
+		// Register the body stream before any of its chunks can arrive
+		if req.BodyStream {
+			if !s.uploading {
+				log.Printf("⚠️  Server streamed a request body without negotiating %s", ws.CapabilityRequestStreaming)
+			}
+			s.uploads.open(req.ID)
+		}
+		go func() {
+			defer s.inflight.Done()
+			handleRequest(s, req)
+		}()
+	case "request_chunk":
+		var chunk RequestChunk
+		if err := json.Unmarshal(msg, &chunk); err != nil {
+			log.Println("❌ JSON unmarshal error:", err)
+			return
+		}
+		s.uploads.deliverChunk(chunk)
+	case "request_end":
+		var end RequestEnd
+		if err := json.Unmarshal(msg, &end); err != nil {
+			log.Println("❌ JSON unmarshal error:", err)
+			return
+		}
+		s.uploads.deliverEnd(end)
+	case "cancel":
+		var cancel RequestCancel
+		if err := json.Unmarshal(msg, &cancel); err != nil {
+			log.Println("❌ JSON unmarshal error:", err)
+			return
+		}
+		s.requests.cancel(cancel.ID, errCanceledByServer)
+	case "ws_open":
+		var open WebSocketOpen
+		if err := json.Unmarshal(msg, &open); err != nil {
+			log.Println("❌ JSON unmarshal error:", err)
+			return
+		}
+		if !s.websocket {
+			log.Printf("❌ WebSocket open for %s without negotiating %s", open.Path, ws.CapabilityWebSocket)
+			s.send(WebSocketOpened{Type: "ws_opened", ID: open.ID, Status: 501, Error: "WebSocket passthrough not enabled"})
+			return
+		}
+		if s.isDraining() {
+			s.send(WebSocketOpened{Type: "ws_opened", ID: open.ID, Status: http.StatusServiceUnavailable, Error: "Tunnel is shutting down"})
+			return
+		}
+		go handleWebSocketOpen(s, open)
+	case "ws_message":
+		var wsMsg WebSocketMessage
+		if err := json.Unmarshal(msg, &wsMsg); err != nil {
+			log.Println("❌ JSON unmarshal error:", err)
+			return
+		}
+		deliverWebSocketMessage(s, wsMsg)
+	case "ws_close":
+		var wsClose WebSocketClose
+		if err := json.Unmarshal(msg, &wsClose); err != nil {
+			log.Println("❌ JSON unmarshal error:", err)
+			return
+		}
+		closeWebSocket(s, wsClose)
+	default:
+		log.Printf("⚠️  Ignoring unknown message type: %s", envelope.Type)
+	}
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultShutdownTimeout is how long a shutdown waits for requests in flight
+const DefaultShutdownTimeout = 10 * time.Second
+
+// NotifyShutdown returns a context canceled by the first SIGINT or SIGTERM,
+// which starts a graceful shutdown. A second signal exits right away
+func NotifyShutdown() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("🛑 Shutting down, press Ctrl-C again to quit immediately")
+		cancel()
+		<-signals
+		log.Println("🛑 Forced quit")
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+// track registers a request handler, unless the session is draining
+func (s *session) track() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining {
+		return false
+	}
+	s.inflight.Add(1)
+	return true
+}
+
+func (s *session) isDraining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
+// shutdown stops taking requests, closes relayed WebSockets, waits up to
+// timeout for the requests in flight to finish and their streamed frames to
+// be written, then unregisters the tunnel and closes the connection with a
+// close frame
+func (s *session) shutdown(timeout time.Duration) {
+	s.mu.Lock()
+	s.draining = true
+	s.mu.Unlock()
+
+	// WebSockets never finish on their own; visitors get a going away close
+	for id, relay := range s.relays.takeAll() {
+		relay.stop(websocket.CloseGoingAway, "tunnel shutting down")
+		s.send(WebSocketClose{Type: "ws_close", ID: id, Code: websocket.CloseGoingAway, Reason: "tunnel shutting down"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	drained := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		log.Println("✅ Requests in flight finished")
+	case <-ctx.Done():
+		log.Printf("⚠️  Requests still in flight after %v, closing anyway", timeout)
+		s.requests.cancelAll(errShuttingDown)
+	}
+	// Finished handlers may have left frames queued behind other streams
+	if err := s.streams.drain(ctx); err != nil && ctx.Err() != nil {
+		log.Printf("⚠️  Streamed responses still being sent after %v, closing anyway", timeout)
+	}
+
+	if err := s.conn.Unregister(); err != nil {
+		log.Printf("⚠️  Warning: Could not unregister tunnel: %v", err)
+	}
+	if err := s.conn.CloseGracefully("client shutting down"); err != nil {
+		log.Printf("⚠️  Warning: Could not close connection cleanly: %v", err)
+	}
+	// Give the server a moment to answer the close frame before dropping the connection
+	time.AfterFunc(time.Second, func() { s.conn.Close() })
+}
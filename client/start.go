@@ -0,0 +1,101 @@
+package client
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/panngo/devpipe-cli/config"
+	"github.com/panngo/devpipe-cli/inspector"
+	"github.com/panngo/devpipe-cli/replay"
+	"github.com/panngo/devpipe-cli/ui"
+	"github.com/panngo/devpipe-cli/ws"
+)
+
+// RunStart implements `devpipe start --config devpipe.yml`: it registers every
+// tunnel declared in the file, each over its own connection and with its own
+// saved credentials, and serves them until they all stop
+func RunStart(args []string) error {
+	fs := flag.NewFlagSet("start", flag.ExitOnError)
+	configPath := fs.String("config", config.DefaultTunnelFile, "Tunnel file to start")
+	server := fs.String("server", "", "DevPipe server WebSocket URL (overrides the tunnel file)")
+	inspect := fs.String("inspect", inspector.DefaultAddr, "Address for the request inspector UI (empty to disable)")
+	history := fs.Bool("history", true, "Keep recent requests on disk for devpipe replay")
+	record := fs.String("record", "", "Append every request/response pair to this JSONL capture file")
+	requestTimeout := fs.Duration("request-timeout", 0, "Abort requests the app takes longer than this to start answering, e.g. 30s (default: no limit)")
+	shutdownTimeout := fs.Duration("shutdown-timeout", DefaultShutdownTimeout, "How long Ctrl-C waits for requests in flight before closing the tunnels")
+	maxConcurrent := fs.Int("max-concurrent", DefaultMaxConcurrent, "Requests sent to each app at once (0 for no limit)")
+	maxQueue := fs.Int("max-queue", DefaultMaxQueue, "Requests waiting for each app before new ones get a 503")
+	reconnectPolicy := reconnectFlags(fs)
+	sharedUpstream := upstreamFlags(fs)
+	fs.Parse(args)
+
+	file, err := config.LoadTunnelFile(*configPath)
+	if err != nil {
+		return err
+	}
+
+	serverFlag := *server
+	if serverFlag == "" {
+		serverFlag = file.Server
+	}
+	configManager := config.NewConfigManager()
+	shared := baseOptions(resolveServerURL(serverFlag, configManager), *inspect, *history, *record, configManager)
+	shared.RequestTimeout = *requestTimeout
+	shared.ShutdownTimeout = *shutdownTimeout
+	if shared.Reconnect, err = resolveReconnectPolicy(fs, *reconnectPolicy, configManager); err != nil {
+		return err
+	}
+
+	transports := upstreamTransports{}
+	tunnels := make([]Options, 0, len(file.Tunnels))
+	defaults := tunnelDefaults{
+		upstream:      *sharedUpstream,
+		maxConcurrent: *maxConcurrent,
+		maxQueue:      *maxQueue,
+	}
+	for _, spec := range file.Specs() {
+		opts, err := tunnelOptions(shared, spec, defaults, configManager)
+		if err != nil {
+			return fmt.Errorf("tunnel %q: %w", spec.Name, err)
+		}
+		transports.addAll(opts)
+		tunnels = append(tunnels, opts)
+	}
+	shared.Inspector.ReplayClient = replay.NewClient(transports)
+
+	conns := make([]*ws.SafeConn, len(tunnels))
+	banner := make([]ui.Tunnel, len(tunnels))
+	for i, opts := range tunnels {
+		conn, tunnelID := ws.ConnectAndRegister(opts.ServerURL, opts.Port, opts.Profile)
+		defer conn.Close()
+		conns[i] = conn
+		banner[i] = ui.Tunnel{Name: opts.Name, URL: ui.TunnelURL(opts.ServerURL, tunnelID), Upstream: opts.Upstream.String()}
+	}
+
+	ui.PrintTunnelsBanner(banner)
+	if shared.InspectAddr != "" {
+		// Bind before printing, so the banner shows the port actually in use
+		if ln, err := shared.Inspector.Listen(shared.InspectAddr); err != nil {
+			log.Printf("⚠️  Inspector disabled: %v", err)
+		} else {
+			go shared.Inspector.Serve(ln)
+			ui.PrintInspectorInfo(ln.Addr().String())
+		}
+	}
+
+	// One Ctrl-C drains and unregisters every tunnel
+	ctx := NotifyShutdown()
+	var wg sync.WaitGroup
+	for i, opts := range tunnels {
+		wg.Add(1)
+		go func(conn *ws.SafeConn, opts Options) {
+			defer wg.Done()
+			ListenAndServe(ctx, conn, opts)
+		}(conns[i], opts)
+	}
+	wg.Wait()
+	shared.Close()
+	return nil
+}
@@ -0,0 +1,271 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+)
+
+const (
+	// streamChunkSize is the largest body slice sent in a single response_chunk
+	streamChunkSize = 32 * 1024
+	// streamWindow is how many frames one request may queue before its
+	// reader blocks, so a single download cannot monopolize the connection
+	streamWindow = 8
+)
+
+var errSchedulerClosed = errors.New("connection closed")
+
+// ResponseStart opens a streamed response with its status and headers
+type ResponseStart struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Status  int    `json:"status"`
+	Headers Header `json:"headers"`
+}
+
+// ResponseChunk carries one slice of a streamed response body
+type ResponseChunk struct {
+	Type         string `json:"type"`
+	ID           string `json:"id"`
+	Seq          int    `json:"seq"`
+	Body         string `json:"body"`
+	BodyEncoding string `json:"body_encoding,omitempty"`
+}
+
+// ResponseEnd closes a streamed response. Seq is the number of chunks sent
+type ResponseEnd struct {
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	Seq   int    `json:"seq"`
+	Error string `json:"error,omitempty"`
+}
+
+// frameScheduler writes streamed frames to the connection, taking one frame
+// from each active stream in turn
+type frameScheduler struct {
+	write   func(v interface{}) error
+	mu      sync.Mutex
+	streams []*frameStream
+	next    int
+	// pending counts the frames queued and not yet written; idle is closed
+	// when it drops to zero while someone drains
+	pending int
+	idle    chan struct{}
+	wake    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// frameStream is the bounded frame queue of a single response
+type frameStream struct {
+	id       string
+	frames   chan interface{}
+	finished bool
+}
+
+func newFrameScheduler(write func(v interface{}) error) *frameScheduler {
+	s := &frameScheduler{
+		write: write,
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// open registers a new stream with the scheduler
+func (s *frameScheduler) open(id string) *frameStream {
+	stream := &frameStream{id: id, frames: make(chan interface{}, streamWindow)}
+	s.mu.Lock()
+	s.streams = append(s.streams, stream)
+	s.mu.Unlock()
+	return stream
+}
+
+// send queues a frame, blocking while the stream's window is full
+func (s *frameScheduler) send(stream *frameStream, frame interface{}) error {
+	s.mu.Lock()
+	s.pending++
+	s.mu.Unlock()
+	select {
+	case stream.frames <- frame:
+	case <-s.done:
+		return errSchedulerClosed
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// finish marks a stream as complete; it is dropped once its queue drains
+func (s *frameScheduler) finish(stream *frameStream) {
+	s.mu.Lock()
+	stream.finished = true
+	s.mu.Unlock()
+}
+
+// drain waits until every queued frame has been written, or ctx ends
+func (s *frameScheduler) drain(ctx context.Context) error {
+	s.mu.Lock()
+	if s.pending == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.idle == nil {
+		s.idle = make(chan struct{})
+	}
+	idle := s.idle
+	s.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-s.done:
+		return errSchedulerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close stops the scheduler and unblocks any pending senders
+func (s *frameScheduler) close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+func (s *frameScheduler) run() {
+	for {
+		frame, ok := s.nextFrame()
+		if !ok {
+			select {
+			case <-s.wake:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		if err := s.write(frame); err != nil {
+			log.Printf("❌ Error sending stream frame: %v", err)
+			s.close()
+			return
+		}
+		s.mu.Lock()
+		s.pending--
+		if s.pending == 0 && s.idle != nil {
+			close(s.idle)
+			s.idle = nil
+		}
+		s.mu.Unlock()
+	}
+}
+
+// nextFrame pops the next frame in round-robin order, pruning finished streams
+func (s *frameScheduler) nextFrame() (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < len(s.streams); {
+		if s.next >= len(s.streams) {
+			s.next = 0
+		}
+		stream := s.streams[s.next]
+		select {
+		case frame := <-stream.frames:
+			s.next++
+			return frame, true
+		default:
+		}
+		if stream.finished {
+			s.streams = append(s.streams[:s.next], s.streams[s.next+1:]...)
+			continue
+		}
+		s.next++
+		i++
+	}
+	return nil, false
+}
+
+// shouldStream reports whether a response body should be streamed rather than buffered
+func shouldStream(s *session, resp *http.Response) bool {
+	if !s.streaming {
+		return false
+	}
+	return resp.ContentLength < 0 || resp.ContentLength > streamChunkSize
+}
+
+// streamResponse relays the local response as it is read, one chunk at a time
+func streamResponse(s *session, req IncomingRequest, resp *http.Response) {
+	stream := s.streams.open(req.ID)
+	defer s.streams.finish(stream)
+
+	start := ResponseStart{
+		Type:    "response_start",
+		ID:      req.ID,
+		Status:  resp.StatusCode,
+		Headers: newHeader(resp.Header),
+	}
+	if err := s.streams.send(stream, start); err != nil {
+		log.Printf("❌ Error starting stream for %s: %v", req.Path, err)
+		return
+	}
+
+	buf := make([]byte, streamChunkSize)
+	seq := 0
+	total := 0
+	var readErr error
+
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			body, bodyEncoding := s.encodeBody(buf[:n], resp.Header)
+			chunk := ResponseChunk{
+				Type:         "response_chunk",
+				ID:           req.ID,
+				Seq:          seq,
+				Body:         body,
+				BodyEncoding: bodyEncoding,
+			}
+			if sendErr := s.streams.send(stream, chunk); sendErr != nil {
+				log.Printf("❌ Stream for %s aborted: %v", req.Path, sendErr)
+				return
+			}
+			seq++
+			total += n
+		}
+		if err != nil {
+			if err != io.EOF {
+				readErr = err
+			}
+			break
+		}
+	}
+
+	end := ResponseEnd{Type: "response_end", ID: req.ID, Seq: seq}
+	cause := context.Cause(resp.Request.Context())
+	switch {
+	case readErr != nil && cause == errRequestTimeout:
+		log.Printf("⏱️  Stream for %s timed out", req.Path)
+		end.Error = "Upstream timed out"
+	case readErr != nil && cause != nil:
+		// Nobody is waiting for the rest of the stream
+		log.Printf("🚫 Stream for %s %v", req.Path, cause)
+		s.printRequest(req.Method, req.Path, resp.StatusCode, "CANCEL")
+		s.observeCanceled(req.ID, cause.Error())
+		return
+	case readErr != nil:
+		log.Printf("❌ Error reading response body: %v", readErr)
+		end.Error = "Failed to read response"
+	}
+	if err := s.streams.send(stream, end); err != nil {
+		log.Printf("❌ Error ending stream for %s: %v", req.Path, err)
+		return
+	}
+
+	log.Printf("📤 Streamed %d bytes in %d chunks for %s", total, seq, req.Path)
+	s.printRequest(req.Method, req.Path, resp.StatusCode, "OK")
+}
@@ -0,0 +1,199 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+)
+
+// uploadWindow is how many request_chunk frames the server may send ahead of
+// our request_ack messages
+const uploadWindow = 16
+
+var errUploadAborted = errors.New("upload aborted")
+
+// RequestChunk carries one slice of a streamed request body
+type RequestChunk struct {
+	Type         string `json:"type"`
+	ID           string `json:"id"`
+	Seq          int    `json:"seq"`
+	Body         string `json:"body"`
+	BodyEncoding string `json:"body_encoding,omitempty"`
+}
+
+// RequestEnd marks the end of a streamed request body
+type RequestEnd struct {
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// RequestAck tells the server a chunk was handed to the local app,
+// freeing a slot in the upload window
+type RequestAck struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	Seq  int    `json:"seq"`
+}
+
+// uploadFrame is a decoded request_chunk or request_end
+type uploadFrame struct {
+	seq  int
+	data []byte
+	end  bool
+	err  error
+}
+
+// requestUpload feeds a streamed request body into the local request
+type requestUpload struct {
+	id     string
+	frames chan uploadFrame
+	reader *io.PipeReader
+	writer *io.PipeWriter
+	done   chan struct{}
+	once   sync.Once
+}
+
+// abort fails both ends of the pipe and stops the pump
+func (u *requestUpload) abort() {
+	u.once.Do(func() {
+		close(u.done)
+		u.writer.CloseWithError(errUploadAborted)
+		u.reader.CloseWithError(errUploadAborted)
+	})
+}
+
+// uploadRegistry tracks the streamed request bodies of a connection
+type uploadRegistry struct {
+	mu      sync.Mutex
+	uploads map[string]*requestUpload
+	ack     func(v interface{}) error
+	observe func(id string, data []byte)
+}
+
+func newUploadRegistry(ack func(v interface{}) error, observe func(id string, data []byte)) *uploadRegistry {
+	return &uploadRegistry{
+		uploads: make(map[string]*requestUpload),
+		ack:     ack,
+		observe: observe,
+	}
+}
+
+// open registers a streamed body for a request. It must be called before
+// any of the request's chunks are delivered
+func (r *uploadRegistry) open(id string) *requestUpload {
+	reader, writer := io.Pipe()
+	upload := &requestUpload{
+		id:     id,
+		frames: make(chan uploadFrame, uploadWindow+1),
+		reader: reader,
+		writer: writer,
+		done:   make(chan struct{}),
+	}
+	r.mu.Lock()
+	r.uploads[id] = upload
+	r.mu.Unlock()
+
+	go r.pump(upload)
+	return upload
+}
+
+// get returns the streamed body of a request, if it has one
+func (r *uploadRegistry) get(id string) *requestUpload {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.uploads[id]
+}
+
+// remove forgets a request's upload and unblocks its pump
+func (r *uploadRegistry) remove(id string) {
+	r.mu.Lock()
+	upload := r.uploads[id]
+	delete(r.uploads, id)
+	r.mu.Unlock()
+
+	if upload != nil {
+		upload.abort()
+	}
+}
+
+// closeAll aborts every pending upload
+func (r *uploadRegistry) closeAll() {
+	r.mu.Lock()
+	uploads := r.uploads
+	r.uploads = make(map[string]*requestUpload)
+	r.mu.Unlock()
+
+	for _, upload := range uploads {
+		upload.abort()
+	}
+}
+
+// deliverChunk queues a request_chunk for its upload
+func (r *uploadRegistry) deliverChunk(chunk RequestChunk) {
+	upload := r.get(chunk.ID)
+	if upload == nil {
+		log.Printf("⚠️  Dropping body chunk for unknown request: %s", chunk.ID)
+		return
+	}
+
+	data, err := decodeBody(chunk.Body, chunk.BodyEncoding)
+	if err != nil {
+		r.deliver(upload, uploadFrame{end: true, err: err})
+		return
+	}
+	r.observe(chunk.ID, data)
+	r.deliver(upload, uploadFrame{seq: chunk.Seq, data: data})
+}
+
+// deliverEnd queues the end of a request body
+func (r *uploadRegistry) deliverEnd(end RequestEnd) {
+	upload := r.get(end.ID)
+	if upload == nil {
+		return
+	}
+
+	frame := uploadFrame{end: true}
+	if end.Error != "" {
+		frame.err = errors.New(end.Error)
+	}
+	r.deliver(upload, frame)
+}
+
+// deliver hands a frame to the upload pump without blocking the read loop.
+// A server that overruns the window gets the upload aborted instead
+func (r *uploadRegistry) deliver(upload *requestUpload, frame uploadFrame) {
+	select {
+	case upload.frames <- frame:
+	default:
+		log.Printf("❌ Upload window exceeded for request %s, aborting body", upload.id)
+		upload.abort()
+	}
+}
+
+// pump writes queued chunks into the pipe as the local app reads them,
+// acknowledging each one so the server can send more
+func (r *uploadRegistry) pump(upload *requestUpload) {
+	for {
+		var frame uploadFrame
+		select {
+		case frame = <-upload.frames:
+		case <-upload.done:
+			return
+		}
+		if frame.end {
+			upload.writer.CloseWithError(frame.err)
+			return
+		}
+		if _, err := upload.writer.Write(frame.data); err != nil {
+			return
+		}
+		ack := RequestAck{Type: "request_ack", ID: upload.id, Seq: frame.seq}
+		if err := r.ack(ack); err != nil {
+			log.Printf("❌ Error acknowledging body chunk: %v", err)
+			upload.writer.CloseWithError(err)
+			return
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Upstream proxy policies, besides an explicit proxy URL
+const (
+	// UpstreamProxyNone connects directly, ignoring HTTP_PROXY and friends
+	UpstreamProxyNone = "none"
+	// UpstreamProxyEnv uses HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	UpstreamProxyEnv = "env"
+)
+
+// Upstream connection defaults. The app may take as long as it likes to
+// answer, so long polls keep working; -request-timeout is the usual way to cap it
+const (
+	DefaultUpstreamDialTimeout   = 10 * time.Second
+	DefaultUpstreamHeaderTimeout = 0
+	DefaultUpstreamIdleTimeout   = 90 * time.Second
+	// DefaultUpstreamMaxIdle is how many keep-alive connections are pooled
+	// per upstream. The net/http default of 2 per host makes a busy app
+	// reconnect for most requests
+	DefaultUpstreamMaxIdle = 32
+)
+
+// UpstreamOptions are the TLS and connection settings of upstream traffic
+type UpstreamOptions struct {
+	Insecure bool
+	CAFile   string
+	// DialTimeout bounds connecting, TLS handshake included
+	DialTimeout time.Duration
+	// HeaderTimeout is how long the app has to start answering, 0 for no limit
+	HeaderTimeout time.Duration
+	// IdleTimeout closes keep-alive connections unused for this long
+	IdleTimeout time.Duration
+	MaxIdle     int
+	// Proxy is none, env or a proxy URL such as http://proxy.internal:3128
+	Proxy string
+}
+
+// upstreamFlags registers the connection settings shared by every upstream
+func upstreamFlags(fs *flag.FlagSet) *UpstreamOptions {
+	opts := &UpstreamOptions{}
+	fs.DurationVar(&opts.DialTimeout, "upstream-dial-timeout", DefaultUpstreamDialTimeout, "How long connecting to the upstream may take")
+	fs.DurationVar(&opts.HeaderTimeout, "upstream-header-timeout", DefaultUpstreamHeaderTimeout, "How long the upstream has to start answering, also for replays (default: no limit)")
+	fs.DurationVar(&opts.IdleTimeout, "upstream-idle-timeout", DefaultUpstreamIdleTimeout, "Close keep-alive connections to the upstream after this long unused")
+	fs.IntVar(&opts.MaxIdle, "upstream-max-idle", DefaultUpstreamMaxIdle, "Keep-alive connections kept open per upstream")
+	fs.StringVar(&opts.Proxy, "upstream-proxy", UpstreamProxyNone, "Proxy for upstream traffic: none, env (HTTP_PROXY and friends) or a proxy URL")
+	return opts
+}
+
+// Upstream is the app tunneled requests are forwarded to
+type Upstream struct {
+	URL *url.URL
+	// TLS is used for https and wss upstreams. The server name for SNI comes
+	// from the upstream host, which is also sent as the Host header
+	TLS       *tls.Config
+	Transport *http.Transport
+	// Client never follows redirects, so the visitor's browser gets them
+	Client *http.Client
+}
+
+// localUpstream is the default upstream, plain HTTP on localhost
+func localUpstream(port string) string {
+	return "http://localhost:" + port
+}
+
+// NewUpstream parses an upstream URL such as https://api.internal:8443.
+// A URL without a scheme is taken as plain HTTP, e.g. api:8080
+func NewUpstream(raw string, opts UpstreamOptions) (*Upstream, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid upstream URL %q: scheme must be http or https", raw)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream URL %q: missing host", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid upstream URL %q: query and fragment are not allowed", raw)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+
+	tlsConfig, err := newTLSConfig(opts.Insecure, opts.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := newUpstreamTransport(tlsConfig, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Upstream{
+		URL:       u,
+		TLS:       tlsConfig,
+		Transport: transport,
+		Client: &http.Client{
+			Transport: transport,
+			// The app's redirects are relayed as they are: following them here
+			// would break relative Location headers and cookies set on the way.
+			// There is no overall timeout, streamed responses can run for long
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}, nil
+}
+
+// newUpstreamTransport builds the transport of an upstream. Compression is
+// left to the app and the visitor, so bodies and Content-Encoding pass through
+// untouched
+func newUpstreamTransport(tlsConfig *tls.Config, opts UpstreamOptions) (*http.Transport, error) {
+	proxy, err := upstreamProxy(opts.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxIdle < 0 {
+		return nil, fmt.Errorf("invalid upstream max idle connections %d", opts.MaxIdle)
+	}
+	dialer := &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: 30 * time.Second}
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   opts.DialTimeout,
+		ResponseHeaderTimeout: opts.HeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       opts.IdleTimeout,
+		MaxIdleConns:          opts.MaxIdle,
+		MaxIdleConnsPerHost:   opts.MaxIdle,
+		ForceAttemptHTTP2:     true,
+		DisableCompression:    true,
+	}, nil
+}
+
+// upstreamProxy resolves a proxy policy. Going direct is the default: a
+// corporate HTTP_PROXY should not see requests meant for a local app
+func upstreamProxy(policy string) (func(*http.Request) (*url.URL, error), error) {
+	switch policy {
+	case "", UpstreamProxyNone:
+		return nil, nil
+	case UpstreamProxyEnv:
+		return http.ProxyFromEnvironment, nil
+	}
+	u, err := url.Parse(policy)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream proxy %q: use %s, %s or a proxy URL", policy, UpstreamProxyNone, UpstreamProxyEnv)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid upstream proxy %q: scheme must be http, https or socks5", policy)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// isTimeout reports whether an upstream call failed on one of the transport
+// timeouts
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// newTLSConfig trusts the system roots plus an optional CA bundle, or skips
+// verification entirely when insecure is set
+func newTLSConfig(insecure bool, caFile string) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("CA file contains no PEM certificates")
+	}
+	config.RootCAs = pool
+	return config, nil
+}
+
+// Target is the base URL request paths are appended to
+func (u *Upstream) Target() string {
+	return u.URL.String()
+}
+
+// WebSocketURL is the ws:// or wss:// URL for a request path
+func (u *Upstream) WebSocketURL(path string) string {
+	wsURL := *u.URL
+	wsURL.Scheme = "ws"
+	if u.URL.Scheme == "https" {
+		wsURL.Scheme = "wss"
+	}
+	return wsURL.String() + path
+}
+
+// Port is the upstream port, defaulting by scheme
+func (u *Upstream) Port() string {
+	if port := u.URL.Port(); port != "" {
+		return port
+	}
+	if u.URL.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// Profile is the default name its credentials are saved under: the port for
+// localhost, or the host and port for anything else
+func (u *Upstream) Profile() string {
+	host := u.URL.Hostname()
+	if host == "localhost" || host == "127.0.0.1" || host == "::1" {
+		return u.Port()
+	}
+	return strings.NewReplacer(":", "-", "[", "", "]", "").Replace(host) + "-" + u.Port()
+}
+
+// String is how the upstream is shown in the banner
+func (u *Upstream) String() string {
+	if u.URL.Scheme == "http" && u.URL.Path == "" {
+		return u.URL.Host
+	}
+	return u.URL.String()
+}
+
+// fallbackTransport sends replays whose target is none of the upstreams
+var fallbackTransport, _ = newUpstreamTransport(&tls.Config{}, UpstreamOptions{
+	DialTimeout:   DefaultUpstreamDialTimeout,
+	HeaderTimeout: DefaultUpstreamHeaderTimeout,
+	IdleTimeout:   DefaultUpstreamIdleTimeout,
+	MaxIdle:       DefaultUpstreamMaxIdle,
+})
+
+// upstreamTransports sends each request over the transport of the upstream
+// it targets, so replays keep the TLS and connection settings of their tunnel
+type upstreamTransports map[string]http.RoundTripper
+
+func (t upstreamTransports) add(u *Upstream) {
+	t[u.URL.Scheme+"://"+u.URL.Host] = u.Transport
+}
+
+// addAll adds the upstream of a tunnel and of each of its routes
+func (t upstreamTransports) addAll(opts Options) {
+	t.add(opts.Upstream)
+	for _, route := range opts.Routes {
+		t.add(route.Upstream)
+	}
+}
+
+func (t upstreamTransports) RoundTrip(req *http.Request) (*http.Response, error) {
+	if transport, ok := t[req.URL.Scheme+"://"+req.URL.Host]; ok {
+		return transport.RoundTrip(req)
+	}
+	return fallbackTransport.RoundTrip(req)
+}
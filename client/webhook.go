@@ -0,0 +1,245 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook providers
+const (
+	WebhookStripe = "stripe"
+	WebhookGitHub = "github"
+	WebhookSlack  = "slack"
+	// WebhookHMAC is a hex or base64 HMAC-SHA256 of the body in a header of your choice
+	WebhookHMAC = "hmac"
+)
+
+const (
+	// DefaultWebhookHeader carries the signature of generic HMAC webhooks
+	DefaultWebhookHeader = "X-Signature"
+	// webhookTolerance is how old a signed timestamp may be, as recommended by Stripe and Slack
+	webhookTolerance = 5 * time.Minute
+	// githubDeliveryTTL is how long a GitHub delivery ID is remembered. GitHub
+	// signs no timestamp, so a replay is only caught within this window
+	githubDeliveryTTL = time.Hour
+	// webhookBodyLimit caps the streamed bodies buffered for verification
+	webhookBodyLimit = 10 << 20
+)
+
+// WebhookVerifier checks provider signatures so forged payloads never reach
+// the app. Providers that sign a timestamp (stripe, slack) also get stale and
+// replayed deliveries rejected, and GitHub deliveries are accepted once.
+// Generic HMAC signatures cover only the body, so they can't tell a replay
+type WebhookVerifier struct {
+	Provider string
+	secret   []byte
+	header   string
+
+	mu sync.Mutex
+	// seen holds the signatures and delivery IDs accepted recently, by expiry
+	seen map[string]time.Time
+}
+
+// newWebhookVerifier parses a -verify-webhook value such as stripe:whsec_...
+// header is only used by generic HMAC webhooks. It returns nil when value is empty
+func newWebhookVerifier(value, header string) (*WebhookVerifier, error) {
+	if value == "" {
+		return nil, nil
+	}
+	provider, secret, ok := strings.Cut(value, ":")
+	if !ok || secret == "" {
+		return nil, errors.New("invalid webhook verification, expected provider:secret")
+	}
+	switch provider {
+	case WebhookStripe, WebhookGitHub, WebhookSlack:
+	case WebhookHMAC:
+		if header == "" {
+			header = DefaultWebhookHeader
+		}
+	default:
+		return nil, fmt.Errorf("invalid webhook provider %q: use %s, %s, %s or %s", provider, WebhookStripe, WebhookGitHub, WebhookSlack, WebhookHMAC)
+	}
+	return &WebhookVerifier{
+		Provider: provider,
+		secret:   []byte(secret),
+		header:   header,
+		seen:     make(map[string]time.Time),
+	}, nil
+}
+
+// verify checks the signature of a webhook body
+func (v *WebhookVerifier) verify(headers Header, body []byte) error {
+	switch v.Provider {
+	case WebhookStripe:
+		return v.verifyStripe(headers, body)
+	case WebhookGitHub:
+		signature, ok := strings.CutPrefix(headers.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok {
+			return errors.New("missing X-Hub-Signature-256 header")
+		}
+		if err := v.compare(signature, body); err != nil {
+			return err
+		}
+		delivery := headers.Get("X-GitHub-Delivery")
+		if delivery == "" {
+			return errors.New("missing X-GitHub-Delivery header")
+		}
+		if !v.remember("github:"+delivery, time.Now().Add(githubDeliveryTTL)) {
+			return errors.New("replayed delivery")
+		}
+		return nil
+	case WebhookSlack:
+		return v.verifySlack(headers, body)
+	default:
+		signature := headers.Get(v.header)
+		if signature == "" {
+			return fmt.Errorf("missing %s header", v.header)
+		}
+		return v.compare(strings.TrimPrefix(signature, "sha256="), body)
+	}
+}
+
+// verifyStripe checks a Stripe-Signature header: t=<timestamp>,v1=<hex>[,v1=...]
+// signing "<timestamp>.<body>"
+func (v *WebhookVerifier) verifyStripe(headers Header, body []byte) error {
+	header := headers.Get("Stripe-Signature")
+	if header == "" {
+		return errors.New("missing Stripe-Signature header")
+	}
+	var timestamp string
+	var signatures []string
+	for _, item := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(item), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return errors.New("malformed Stripe-Signature header")
+	}
+	signed := append([]byte(timestamp+"."), body...)
+	for _, signature := range signatures {
+		if v.compare(signature, signed) == nil {
+			return v.checkFresh(timestamp, signature)
+		}
+	}
+	return errors.New("signature mismatch")
+}
+
+// verifySlack checks X-Slack-Signature: v0=<hex> signing "v0:<timestamp>:<body>"
+func (v *WebhookVerifier) verifySlack(headers Header, body []byte) error {
+	timestamp := headers.Get("X-Slack-Request-Timestamp")
+	signature, ok := strings.CutPrefix(headers.Get("X-Slack-Signature"), "v0=")
+	if timestamp == "" || !ok {
+		return errors.New("missing X-Slack-Signature or X-Slack-Request-Timestamp header")
+	}
+	if err := v.compare(signature, append([]byte("v0:"+timestamp+":"), body...)); err != nil {
+		return err
+	}
+	return v.checkFresh(timestamp, signature)
+}
+
+// compare checks a hex or base64 HMAC-SHA256 signature of payload
+func (v *WebhookVerifier) compare(signature string, payload []byte) error {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+
+	given, err := hex.DecodeString(signature)
+	if err != nil {
+		given, err = base64.StdEncoding.DecodeString(signature)
+	}
+	if err != nil || !hmac.Equal(given, expected) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// checkFresh rejects timestamps outside the tolerance and signatures already
+// accepted, which is what a replayed delivery looks like
+func (v *WebhookVerifier) checkFresh(timestamp, signature string) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid signature timestamp")
+	}
+	signedAt := time.Unix(seconds, 0)
+	now := time.Now()
+	if now.Sub(signedAt) > webhookTolerance || signedAt.Sub(now) > webhookTolerance {
+		return fmt.Errorf("signature timestamp is more than %v away", webhookTolerance)
+	}
+
+	if !v.remember(signature, signedAt.Add(webhookTolerance)) {
+		return errors.New("replayed delivery")
+	}
+	return nil
+}
+
+// remember records key until expires. It returns false when key was already
+// seen and hasn't expired
+func (v *WebhookVerifier) remember(key string, expires time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	for seen, seenExpires := range v.seen {
+		if now.After(seenExpires) {
+			delete(v.seen, seen)
+		}
+	}
+	if _, ok := v.seen[key]; ok {
+		return false
+	}
+	v.seen[key] = expires
+	return true
+}
+
+// readWebhookBody buffers a streamed body so its signature can be checked
+func readWebhookBody(body io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, webhookBodyLimit+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > webhookBodyLimit {
+		return nil, fmt.Errorf("body is over the %d MB webhook limit", webhookBodyLimit>>20)
+	}
+	return data, nil
+}
+
+// verifyWebhook checks a request against the webhook verifier and tags the
+// result in the inspector. Invalid requests are answered with 401
+func verifyWebhook(s *session, req IncomingRequest, body []byte) bool {
+	provider := s.opts.Webhook.Provider
+	if err := s.opts.Webhook.verify(req.Headers, body); err != nil {
+		log.Printf("🚫 Rejected %s webhook %s %s: %v", provider, req.Method, req.Path, err)
+		s.observeWebhook(req.ID, provider+": "+err.Error())
+		response := OutgoingResponse{
+			ID:      req.ID,
+			Status:  http.StatusUnauthorized,
+			Headers: Header{"Content-Type": {"text/plain"}},
+			Body:    "Invalid webhook signature",
+		}
+		s.printRequest(req.Method, req.Path, response.Status, "SIG")
+
+		if err := s.send(response); err != nil {
+			log.Printf("❌ Error sending error response: %v", err)
+		}
+		return false
+	}
+
+	log.Printf("✅ Verified %s webhook signature", provider)
+	s.observeWebhook(req.ID, provider+": verified")
+	return true
+}
@@ -0,0 +1,346 @@
+package client
+
+import (
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsRelayBuffer is how many server messages may wait for a slow local socket
+const wsRelayBuffer = 256
+
+// Headers the WebSocket dialer sets itself and rejects if supplied
+var wsDialerHeaders = map[string]bool{
+	"host":                     true,
+	"upgrade":                  true,
+	"connection":               true,
+	"sec-websocket-key":        true,
+	"sec-websocket-version":    true,
+	"sec-websocket-extensions": true,
+	"sec-websocket-protocol":   true,
+}
+
+// WebSocketOpen asks the client to open a WebSocket to the local app
+type WebSocketOpen struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Path    string `json:"path"`
+	Headers Header `json:"headers"`
+	// ClientIP is the visitor address as seen by the edge, when it reports it
+	ClientIP string `json:"client_ip,omitempty"`
+}
+
+// WebSocketOpened reports the outcome of a ws_open, with the upgrade
+// response status and headers from the local app
+type WebSocketOpened struct {
+	Type     string `json:"type"`
+	ID       string `json:"id"`
+	Status   int    `json:"status"`
+	Headers  Header `json:"headers,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// WebSocketMessage carries one WebSocket data frame in either direction
+type WebSocketMessage struct {
+	Type         string `json:"type"`
+	ID           string `json:"id"`
+	Opcode       string `json:"opcode"`
+	Body         string `json:"body"`
+	BodyEncoding string `json:"body_encoding,omitempty"`
+}
+
+// WebSocketClose closes a relayed WebSocket in either direction
+type WebSocketClose struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Code   int    `json:"code,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// wsRelay connects one public WebSocket to its local counterpart
+type wsRelay struct {
+	id       string
+	upstream *websocket.Conn
+	outgoing chan WebSocketMessage
+	done     chan struct{}
+	once     sync.Once
+}
+
+// stop closes the local socket, sending a close frame with the given code
+func (r *wsRelay) stop(code int, reason string) {
+	r.once.Do(func() {
+		close(r.done)
+		// 1005, 1006 and 1015 describe a missing close frame and may not be sent
+		switch code {
+		case websocket.CloseNoStatusReceived, websocket.CloseAbnormalClosure, websocket.CloseTLSHandshake:
+			code = websocket.CloseNormalClosure
+		}
+		msg := websocket.FormatCloseMessage(code, reason)
+		r.upstream.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		r.upstream.Close()
+	})
+}
+
+// wsRelayRegistry tracks the WebSockets relayed over a connection
+type wsRelayRegistry struct {
+	mu     sync.Mutex
+	relays map[string]*wsRelay
+}
+
+func newWSRelayRegistry() *wsRelayRegistry {
+	return &wsRelayRegistry{relays: make(map[string]*wsRelay)}
+}
+
+func (r *wsRelayRegistry) get(id string) *wsRelay {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.relays[id]
+}
+
+func (r *wsRelayRegistry) add(relay *wsRelay) {
+	r.mu.Lock()
+	r.relays[relay.id] = relay
+	r.mu.Unlock()
+}
+
+func (r *wsRelayRegistry) remove(id string) *wsRelay {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	relay := r.relays[id]
+	delete(r.relays, id)
+	return relay
+}
+
+// takeAll removes and returns every relay
+func (r *wsRelayRegistry) takeAll() map[string]*wsRelay {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	relays := r.relays
+	r.relays = make(map[string]*wsRelay)
+	return relays
+}
+
+// closeAll closes every local socket, e.g. when the tunnel connection drops
+func (r *wsRelayRegistry) closeAll() {
+	for _, relay := range r.takeAll() {
+		relay.stop(websocket.CloseGoingAway, "tunnel disconnected")
+	}
+}
+
+// handleWebSocketOpen dials the local app and starts relaying frames both ways
+func handleWebSocketOpen(s *session, open WebSocketOpen) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("❌ Panic in WebSocket handler: %v", r)
+		}
+	}()
+
+	ip := s.clientIP(open.ClientIP, open.Headers)
+	if ok, reason := s.opts.IPFilter.check(ip); !ok {
+		log.Printf("⛔ Blocked WebSocket %s from %s: %s", open.Path, displayIP(ip), reason)
+		opened := WebSocketOpened{Type: "ws_opened", ID: open.ID, Status: http.StatusForbidden, Error: "Forbidden"}
+		if err := s.send(opened); err != nil {
+			log.Printf("❌ Error sending WebSocket open result: %v", err)
+		}
+		s.printRequest("GET", open.Path, opened.Status, "IP")
+		return
+	}
+
+	gate := s.opts.Auth.check(open.Path, open.Headers)
+	if !gate.ok {
+		log.Printf("🔒 Rejected WebSocket %s: missing or invalid credentials", open.Path)
+		opened := WebSocketOpened{
+			Type:    "ws_opened",
+			ID:      open.ID,
+			Status:  http.StatusUnauthorized,
+			Headers: Header{"Www-Authenticate": s.opts.Auth.challenges()},
+			Error:   "Unauthorized",
+		}
+		if err := s.send(opened); err != nil {
+			log.Printf("❌ Error sending WebSocket open result: %v", err)
+		}
+		s.printRequest("GET", open.Path, opened.Status, "AUTH")
+		return
+	}
+	open.Path, open.Headers = gate.path, gate.headers
+
+	headers, ok := s.opts.OAuth.check(s, open.Headers)
+	if !ok {
+		log.Printf("🔒 Rejected WebSocket %s: not logged in", open.Path)
+		opened := WebSocketOpened{Type: "ws_opened", ID: open.ID, Status: http.StatusUnauthorized, Error: "Login required"}
+		if err := s.send(opened); err != nil {
+			log.Printf("❌ Error sending WebSocket open result: %v", err)
+		}
+		s.printRequest("GET", open.Path, opened.Status, "LOGIN")
+		return
+	}
+	open.Headers = headers
+
+	upstreamTarget, path := s.route(open.Path)
+	url := upstreamTarget.WebSocketURL(path)
+
+	header := http.Header{}
+	// Dial the way HTTP requests to this upstream connect, proxy policy included
+	dialer := websocket.Dialer{
+		TLSClientConfig:  upstreamTarget.TLS,
+		Proxy:            upstreamTarget.Transport.Proxy,
+		NetDialContext:   upstreamTarget.Transport.DialContext,
+		HandshakeTimeout: upstreamTarget.Transport.ResponseHeaderTimeout,
+	}
+	for k, values := range open.Headers {
+		if strings.EqualFold(k, "Sec-WebSocket-Protocol") {
+			for _, v := range values {
+				for _, protocol := range strings.Split(v, ",") {
+					dialer.Subprotocols = append(dialer.Subprotocols, strings.TrimSpace(protocol))
+				}
+			}
+			continue
+		}
+		if wsDialerHeaders[strings.ToLower(k)] {
+			continue
+		}
+		for _, v := range values {
+			header.Add(k, v)
+		}
+	}
+
+	s.rewriteWebSocket(header, s.headerValues(ip))
+
+	log.Printf("🔌 WebSocket %s", open.Path)
+	upstream, resp, err := dialer.Dial(url, header)
+	if err != nil {
+		log.Printf("❌ WebSocket dial failed for %s: %v", url, err)
+		opened := WebSocketOpened{Type: "ws_opened", ID: open.ID, Status: 502, Error: "WebSocket connection failed"}
+		if resp != nil {
+			opened.Status = resp.StatusCode
+			opened.Headers = newHeader(resp.Header)
+		}
+		if err := s.send(opened); err != nil {
+			log.Printf("❌ Error sending WebSocket open result: %v", err)
+		}
+		s.printRequest("GET", open.Path, opened.Status, "WS")
+		return
+	}
+
+	relay := &wsRelay{
+		id:       open.ID,
+		upstream: upstream,
+		outgoing: make(chan WebSocketMessage, wsRelayBuffer),
+		done:     make(chan struct{}),
+	}
+	s.relays.add(relay)
+
+	opened := WebSocketOpened{
+		Type:     "ws_opened",
+		ID:       open.ID,
+		Status:   resp.StatusCode,
+		Headers:  newHeader(resp.Header),
+		Protocol: upstream.Subprotocol(),
+	}
+	if err := s.send(opened); err != nil {
+		log.Printf("❌ Error sending WebSocket open result: %v", err)
+		s.relays.remove(open.ID)
+		relay.stop(websocket.CloseGoingAway, "tunnel disconnected")
+		return
+	}
+	s.printRequest("GET", open.Path, resp.StatusCode, "WS")
+
+	go writeToLocalWebSocket(relay)
+	readFromLocalWebSocket(s, relay)
+}
+
+// readFromLocalWebSocket relays frames from the local app to the server until either side closes
+func readFromLocalWebSocket(s *session, relay *wsRelay) {
+	stream := s.streams.open(relay.id)
+	defer s.streams.finish(stream)
+
+	for {
+		messageType, data, err := relay.upstream.ReadMessage()
+		if err != nil {
+			if s.relays.remove(relay.id) == nil {
+				// Closed from the server side
+				return
+			}
+			closeMsg := WebSocketClose{Type: "ws_close", ID: relay.id, Code: websocket.CloseNormalClosure}
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				closeMsg.Code = closeErr.Code
+				closeMsg.Reason = closeErr.Text
+			} else {
+				log.Printf("❌ WebSocket read error: %v", err)
+				closeMsg.Code = websocket.CloseAbnormalClosure
+			}
+			relay.stop(closeMsg.Code, closeMsg.Reason)
+			s.streams.send(stream, closeMsg)
+			return
+		}
+
+		msg := WebSocketMessage{Type: "ws_message", ID: relay.id, Opcode: "text", Body: string(data)}
+		if messageType == websocket.BinaryMessage {
+			msg.Opcode = "binary"
+			msg.Body = base64.StdEncoding.EncodeToString(data)
+			msg.BodyEncoding = BodyEncodingBase64
+		}
+		if err := s.streams.send(stream, msg); err != nil {
+			relay.stop(websocket.CloseGoingAway, "tunnel disconnected")
+			return
+		}
+	}
+}
+
+// writeToLocalWebSocket forwards queued server messages to the local app
+func writeToLocalWebSocket(relay *wsRelay) {
+	for {
+		select {
+		case msg := <-relay.outgoing:
+			data, err := decodeBody(msg.Body, msg.BodyEncoding)
+			if err != nil {
+				log.Printf("❌ Invalid WebSocket message: %v", err)
+				continue
+			}
+			messageType := websocket.TextMessage
+			if msg.Opcode == "binary" {
+				messageType = websocket.BinaryMessage
+			}
+			if err := relay.upstream.WriteMessage(messageType, data); err != nil {
+				return
+			}
+		case <-relay.done:
+			return
+		}
+	}
+}
+
+// deliverWebSocketMessage queues a server message for the local socket
+func deliverWebSocketMessage(s *session, msg WebSocketMessage) {
+	relay := s.relays.get(msg.ID)
+	if relay == nil {
+		return
+	}
+	select {
+	case relay.outgoing <- msg:
+	default:
+		log.Printf("❌ Local WebSocket %s is not keeping up, closing it", msg.ID)
+		s.relays.remove(msg.ID)
+		relay.stop(websocket.CloseTryAgainLater, "relay buffer full")
+		s.send(WebSocketClose{Type: "ws_close", ID: msg.ID, Code: websocket.CloseTryAgainLater})
+	}
+}
+
+// closeWebSocket closes the local socket after the public client went away
+func closeWebSocket(s *session, msg WebSocketClose) {
+	relay := s.relays.remove(msg.ID)
+	if relay == nil {
+		return
+	}
+	code := msg.Code
+	if code == 0 {
+		code = websocket.CloseNormalClosure
+	}
+	relay.stop(code, msg.Reason)
+}
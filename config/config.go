@@ -1,10 +1,14 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 )
 
 type TunnelConfig struct {
@@ -14,8 +18,29 @@ type TunnelConfig struct {
 	Port       string `json:"port"`
 }
 
+// DefaultServerURL is the devpipe server used when none is configured
+const DefaultServerURL = "wss://devpipe.cloud/ws"
+
+// Settings holds user preferences read from ~/.devpipe/config.json
+type Settings struct {
+	ServerURL string            `json:"server_url,omitempty"`
+	Reconnect ReconnectSettings `json:"reconnect"`
+}
+
+// ReconnectSettings override the default reconnect policy. Delays are
+// durations such as "2s"; max_attempts 0 retries forever
+type ReconnectSettings struct {
+	MaxAttempts *int     `json:"max_attempts,omitempty"`
+	BaseDelay   string   `json:"base_delay,omitempty"`
+	MaxDelay    string   `json:"max_delay,omitempty"`
+	Jitter      *float64 `json:"jitter,omitempty"`
+}
+
 type ConfigManager struct {
-	configPath string
+	configDir    string
+	configPath   string
+	settingsPath string
+	lockPath     string
 }
 
 func NewConfigManager() *ConfigManager {
@@ -31,25 +56,96 @@ func NewConfigManager() *ConfigManager {
 	}
 	
 	return &ConfigManager{
-		configPath: filepath.Join(configDir, "tunnel.json"),
+		configDir:    configDir,
+		configPath:   filepath.Join(configDir, legacyTunnelFile),
+		settingsPath: filepath.Join(configDir, "config.json"),
+		lockPath:     filepath.Join(configDir, "config.lock"),
+	}
+}
+
+// legacyTunnelFile held the credentials of the only tunnel before profiles
+const legacyTunnelFile = "tunnel.json"
+
+// Profile names double as credential file names, so keep them simple
+var profilePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateProfile checks that a profile or tunnel name can be used as a file name
+func ValidateProfile(name string) error {
+	if !profilePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q: use letters, digits, '.', '-' and '_'", name)
 	}
+	return nil
+}
+
+// ForProfile returns a manager that keeps the credentials of a profile in
+// their own file under tunnels/, so tunnels running side by side don't
+// overwrite each other. An empty profile keeps using tunnel.json
+func (cm *ConfigManager) ForProfile(profile string) *ConfigManager {
+	if profile == "" {
+		return cm
+	}
+	tunnelsDir := filepath.Join(cm.configDir, "tunnels")
+	if err := os.MkdirAll(tunnelsDir, 0755); err != nil {
+		tunnelsDir = cm.configDir
+	}
+	named := *cm
+	named.configPath = filepath.Join(tunnelsDir, profile+".json")
+	return &named
 }
 
 func (cm *ConfigManager) SaveTunnelConfig(config TunnelConfig) error {
-	data, err := json.MarshalIndent(config, "", "  ")
+	unlock, err := lockFile(cm.lockPath)
 	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
+		return err
 	}
+	defer unlock()
 	
-	if err := os.WriteFile(cm.configPath, data, 0600); err != nil {
-		return fmt.Errorf("failed to write config file: %w", err)
+	return writeTunnelConfig(cm.configPath, config)
+}
+
+func (cm *ConfigManager) LoadTunnelConfig() (*TunnelConfig, error) {
+	unlock, err := lockFile(cm.lockPath)
+	if err != nil {
+		return nil, err
 	}
+	defer unlock()
 	
+	return readTunnelConfig(cm.configPath)
+}
+
+// ImportLegacyTunnelConfig moves the credentials saved in tunnel.json by older
+// versions into this profile, if they were saved for the given port and the
+// profile has none of its own yet
+func (cm *ConfigManager) ImportLegacyTunnelConfig(port string) error {
+	legacyPath := filepath.Join(cm.configDir, legacyTunnelFile)
+	if cm.configPath == legacyPath {
+		return nil
+	}
+
+	unlock, err := lockFile(cm.lockPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(cm.configPath); err == nil {
+		return nil
+	}
+	legacy, err := readTunnelConfig(legacyPath)
+	if err != nil || legacy == nil || legacy.Port != port {
+		return err
+	}
+	if err := writeTunnelConfig(cm.configPath, *legacy); err != nil {
+		return err
+	}
+	if err := os.Remove(legacyPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove legacy config file: %w", err)
+	}
 	return nil
 }
 
-func (cm *ConfigManager) LoadTunnelConfig() (*TunnelConfig, error) {
-	data, err := os.ReadFile(cm.configPath)
+func readTunnelConfig(path string) (*TunnelConfig, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil // No config file exists yet
@@ -65,7 +161,77 @@ func (cm *ConfigManager) LoadTunnelConfig() (*TunnelConfig, error) {
 	return &config, nil
 }
 
+func writeTunnelConfig(path string, config TunnelConfig) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	return nil
+}
+
+// Secret returns a random key kept in the config dir under name, creating it
+// on first use, so signed cookies stay valid across restarts
+func (cm *ConfigManager) Secret(name string) ([]byte, error) {
+	unlock, err := lockFile(cm.lockPath)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	path := filepath.Join(cm.configDir, name)
+	if data, err := os.ReadFile(path); err == nil {
+		if key, err := hex.DecodeString(strings.TrimSpace(string(data))); err == nil && len(key) >= 32 {
+			return key, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+	if err := writeFileAtomic(path, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write secret: %w", err)
+	}
+	return key, nil
+}
+
+// HistoryDir returns the directory where recorded requests are kept for replay
+func (cm *ConfigManager) HistoryDir() string {
+	return filepath.Join(cm.configDir, "history")
+}
+
+// LoadSettings reads the user settings file, returning empty settings if it does not exist
+func (cm *ConfigManager) LoadSettings() (*Settings, error) {
+	data, err := os.ReadFile(cm.settingsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Settings{}, nil
+		}
+		return nil, fmt.Errorf("failed to read settings file: %w", err)
+	}
+
+	var settings Settings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal settings: %w", err)
+	}
+
+	return &settings, nil
+}
+
 func (cm *ConfigManager) ClearTunnelConfig() error {
+	unlock, err := lockFile(cm.lockPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	if err := os.Remove(cm.configPath); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to remove config file: %w", err)
 	}
@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package config
+
+import "os"
+
+// Platforms without file locking rely on atomic renames alone
+func lock(file *os.File) error {
+	return nil
+}
+
+func unlock(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package config
+
+import (
+	"os"
+	"syscall"
+)
+
+func lock(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
+
+func unlock(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultTunnelFile is the file read by `devpipe start` when no --config is given
+const DefaultTunnelFile = "devpipe.yml"
+
+// TunnelFile declares the tunnels started together by `devpipe start`
+type TunnelFile struct {
+	Server  string                `yaml:"server"`
+	Tunnels map[string]TunnelSpec `yaml:"tunnels"`
+}
+
+// TunnelSpec is one named tunnel. Upstream takes precedence over Port
+type TunnelSpec struct {
+	Name             string           `yaml:"-"`
+	Port             string           `yaml:"port"`
+	Upstream         string           `yaml:"upstream"`
+	UpstreamInsecure bool             `yaml:"upstream_insecure"`
+	UpstreamCA       string           `yaml:"upstream_ca"`
+	Routes           []RouteSpec      `yaml:"routes"`
+	CORS             CORSSpec         `yaml:"cors"`
+	BasicAuth        string           `yaml:"basic_auth"`
+	AuthToken        string           `yaml:"auth_token"`
+	AllowCIDR        []string         `yaml:"allow_cidr"`
+	DenyCIDR         []string         `yaml:"deny_cidr"`
+	OAuth            OAuthSpec        `yaml:"oauth"`
+	VerifyWebhook    string           `yaml:"verify_webhook"`
+	WebhookHeader    string           `yaml:"webhook_header"`
+	RequestHeaders   []HeaderRuleSpec `yaml:"request_headers"`
+	ResponseHeaders  []HeaderRuleSpec `yaml:"response_headers"`
+}
+
+// OAuthSpec puts a tunnel behind a login with google, github or any OIDC
+// issuer, for the listed email domains and addresses
+type OAuthSpec struct {
+	Provider     string   `yaml:"provider"`
+	Issuer       string   `yaml:"issuer"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	AllowDomains []string `yaml:"allow_domains"`
+	AllowEmails  []string `yaml:"allow_emails"`
+}
+
+// CORSSpec configures how CORS is handled: passthrough (the default), auto or policy
+type CORSSpec struct {
+	Mode        string   `yaml:"mode"`
+	Origins     []string `yaml:"origins"`
+	Methods     []string `yaml:"methods"`
+	Headers     []string `yaml:"headers"`
+	Credentials bool     `yaml:"credentials"`
+	MaxAge      int      `yaml:"max_age"`
+}
+
+// RouteSpec sends the paths matching Path to another upstream. A Path ending
+// in /* matches that prefix; anything else must match exactly
+type RouteSpec struct {
+	Path        string `yaml:"path"`
+	Upstream    string `yaml:"upstream"`
+	StripPrefix bool   `yaml:"strip_prefix"`
+}
+
+// ParseRoute parses the -route flag syntax, PATH=UPSTREAM[,strip],
+// e.g. /api/*=:8080,strip
+func ParseRoute(value string) (RouteSpec, error) {
+	path, target, ok := strings.Cut(value, "=")
+	if !ok || path == "" || target == "" {
+		return RouteSpec{}, fmt.Errorf("invalid route %q, expected PATH=UPSTREAM[,strip]", value)
+	}
+	route := RouteSpec{Path: strings.TrimSpace(path), Upstream: strings.TrimSpace(target)}
+	if upstream, option, ok := strings.Cut(route.Upstream, ","); ok {
+		if strings.TrimSpace(option) != "strip" {
+			return RouteSpec{}, fmt.Errorf("invalid route %q: unknown option %q", value, option)
+		}
+		route.Upstream = strings.TrimSpace(upstream)
+		route.StripPrefix = true
+	}
+	return route, route.Validate()
+}
+
+// Validate checks the route path and target
+func (r RouteSpec) Validate() error {
+	if !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("invalid route path %q: must start with /", r.Path)
+	}
+	if strings.Contains(strings.TrimSuffix(r.Path, "*"), "*") {
+		return fmt.Errorf("invalid route path %q: * is only allowed at the end", r.Path)
+	}
+	if r.Upstream == "" {
+		return fmt.Errorf("route %q has no upstream", r.Path)
+	}
+	return nil
+}
+
+// Header rule actions
+const (
+	HeaderSet    = "set"
+	HeaderAdd    = "add"
+	HeaderRemove = "remove"
+)
+
+// HeaderRuleSpec sets, adds or removes a request or response header. Values
+// may use the {tunnel_url}, {tunnel_host} and {client_ip} placeholders
+type HeaderRuleSpec struct {
+	Action string `yaml:"action"`
+	Name   string `yaml:"name"`
+	Value  string `yaml:"value"`
+}
+
+// ParseHeaderRule parses the -request-header and -response-header flag
+// syntax: "set NAME: VALUE", "add NAME: VALUE" or "remove NAME"
+func ParseHeaderRule(value string) (HeaderRuleSpec, error) {
+	action, rest, _ := strings.Cut(strings.TrimSpace(value), " ")
+	name, headerValue, hasValue := strings.Cut(rest, ":")
+	rule := HeaderRuleSpec{Action: action, Name: strings.TrimSpace(name), Value: strings.TrimSpace(headerValue)}
+	if action != HeaderRemove && !hasValue {
+		return HeaderRuleSpec{}, fmt.Errorf("invalid header rule %q, expected \"set NAME: VALUE\", \"add NAME: VALUE\" or \"remove NAME\"", value)
+	}
+	return rule, rule.Validate()
+}
+
+// Validate checks the rule action and header name
+func (r HeaderRuleSpec) Validate() error {
+	switch r.Action {
+	case HeaderSet, HeaderAdd, HeaderRemove:
+	default:
+		return fmt.Errorf("invalid header rule action %q: use %s, %s or %s", r.Action, HeaderSet, HeaderAdd, HeaderRemove)
+	}
+	if r.Name == "" || strings.ContainsAny(r.Name, " \t:") {
+		return fmt.Errorf("invalid header name %q", r.Name)
+	}
+	return nil
+}
+
+// LoadTunnelFile reads and validates a tunnel file. Relative CA paths are
+// resolved against the directory of the file
+func LoadTunnelFile(path string) (*TunnelFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tunnel file: %w", err)
+	}
+
+	var file TunnelFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse tunnel file %s: %w", path, err)
+	}
+	if len(file.Tunnels) == 0 {
+		return nil, fmt.Errorf("tunnel file %s declares no tunnels", path)
+	}
+
+	for name, spec := range file.Tunnels {
+		if err := ValidateProfile(name); err != nil {
+			return nil, fmt.Errorf("tunnel %q: %w", name, err)
+		}
+		if spec.Port == "" && spec.Upstream == "" {
+			return nil, fmt.Errorf("tunnel %q needs a port or an upstream", name)
+		}
+		for _, route := range spec.Routes {
+			if err := route.Validate(); err != nil {
+				return nil, fmt.Errorf("tunnel %q: %w", name, err)
+			}
+		}
+		for _, rule := range append(spec.RequestHeaders, spec.ResponseHeaders...) {
+			if err := rule.Validate(); err != nil {
+				return nil, fmt.Errorf("tunnel %q: %w", name, err)
+			}
+		}
+		if spec.UpstreamCA != "" && !filepath.IsAbs(spec.UpstreamCA) {
+			spec.UpstreamCA = filepath.Join(filepath.Dir(path), spec.UpstreamCA)
+		}
+		spec.Name = name
+		file.Tunnels[name] = spec
+	}
+	return &file, nil
+}
+
+// Specs returns the tunnels sorted by name
+func (f *TunnelFile) Specs() []TunnelSpec {
+	specs := make([]TunnelSpec, 0, len(f.Tunnels))
+	for _, spec := range f.Tunnels {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
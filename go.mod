@@ -5,10 +5,11 @@ go 1.22
 require (
 	github.com/fatih/color v1.18.0
 	github.com/gorilla/websocket v1.5.3
+	golang.org/x/sys v0.25.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	golang.org/x/sys v0.25.0 // indirect
 )
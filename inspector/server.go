@@ -0,0 +1,253 @@
+package inspector
+
+import (
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/panngo/devpipe-cli/replay"
+)
+
+// DefaultAddr is where the inspector UI listens unless configured otherwise
+const DefaultAddr = "127.0.0.1:4040"
+
+// listenAttempts is how many ports from the configured one Listen tries, so
+// several devpipe processes can run side by side
+const listenAttempts = 10
+
+//go:embed index.html
+var indexHTML []byte
+
+// summary is the list view of an exchange
+type summary struct {
+	ID           string    `json:"id"`
+	Tunnel       string    `json:"tunnel,omitempty"`
+	Webhook      string    `json:"webhook,omitempty"`
+	Method       string    `json:"method"`
+	Path         string    `json:"path"`
+	Status       int       `json:"status"`
+	Started      time.Time `json:"started"`
+	DurationMs   float64   `json:"duration_ms"`
+	ResponseSize int64     `json:"response_size"`
+	Done         bool      `json:"done"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// detail is an exchange with its bodies, base64-encoded when not valid UTF-8
+type detail struct {
+	Exchange
+	DurationMs           float64 `json:"duration_ms"`
+	RequestBody          string  `json:"request_body"`
+	RequestBodyEncoding  string  `json:"request_body_encoding,omitempty"`
+	ResponseBody         string  `json:"response_body"`
+	ResponseBodyEncoding string  `json:"response_body_encoding,omitempty"`
+}
+
+// Handler serves the inspector UI and its JSON API
+func (s *Store) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(indexHTML)
+	})
+	mux.HandleFunc("GET /api/requests", s.handleList)
+	mux.HandleFunc("GET /api/requests/{id}", s.handleGet)
+	mux.HandleFunc("POST /api/requests/{id}/replay", s.handleReplay)
+	mux.HandleFunc("GET /api/stats", s.handleStats)
+	return mux
+}
+
+// Listen binds the inspector to addr or, when another process already uses
+// it, to one of the next ports. The listener's address is the one to show
+func (s *Store) Listen(addr string) (net.Listener, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inspector address %q: %w", addr, err)
+	}
+	first, err := strconv.Atoi(port)
+	if err != nil || first == 0 {
+		return net.Listen("tcp", addr)
+	}
+	for i := 0; ; i++ {
+		ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(first+i)))
+		if err == nil || !errors.Is(err, syscall.EADDRINUSE) || i == listenAttempts-1 {
+			return ln, err
+		}
+	}
+}
+
+// Serve runs the inspector on ln until the listener fails
+func (s *Store) Serve(ln net.Listener) {
+	if err := http.Serve(ln, localOnly(ln.Addr().String(), s.Handler())); err != nil {
+		log.Printf("⚠️  Inspector stopped: %v", err)
+	}
+}
+
+// localOnly refuses requests whose Host isn't the inspector address. A page
+// that rebinds its own DNS name to 127.0.0.1 passes as same-origin, but its
+// requests still carry its own host name, so this keeps it away from the
+// captured traffic and from replays
+func localOnly(addr string, next http.Handler) http.Handler {
+	_, port, _ := net.SplitHostPort(addr)
+	allowed := map[string]bool{
+		strings.ToLower(addr): true,
+		"localhost:" + port:   true,
+		"127.0.0.1:" + port:   true,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowed[strings.ToLower(r.Host)] {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Store) handleList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	exchanges := s.List(Filter{
+		Method: query.Get("method"),
+		Status: query.Get("status"),
+		Search: query.Get("q"),
+	})
+
+	summaries := make([]summary, 0, len(exchanges))
+	for _, ex := range exchanges {
+		summaries = append(summaries, summary{
+			ID:           ex.ID,
+			Tunnel:       ex.Tunnel,
+			Webhook:      ex.Webhook,
+			Method:       ex.Method,
+			Path:         ex.Path,
+			Status:       ex.Status,
+			Started:      ex.Started,
+			DurationMs:   durationMs(ex),
+			ResponseSize: ex.ResponseSize,
+			Done:         ex.Done,
+			Error:        ex.Error,
+		})
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (s *Store) handleGet(w http.ResponseWriter, r *http.Request) {
+	ex, ok := s.Get(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "request not found"})
+		return
+	}
+
+	d := detail{Exchange: ex, DurationMs: durationMs(ex)}
+	d.RequestBody, d.RequestBodyEncoding = encodeBody(ex.RequestBody)
+	d.ResponseBody, d.ResponseBodyEncoding = encodeBody(ex.ResponseBody)
+	writeJSON(w, http.StatusOK, d)
+}
+
+func (s *Store) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Loads())
+}
+
+// replayRequest is the body of a replay action. Omitted fields keep the captured values
+type replayRequest struct {
+	Headers      map[string]string `json:"headers"`
+	Body         *string           `json:"body"`
+	BodyEncoding string            `json:"body_encoding"`
+	Target       string            `json:"target"`
+}
+
+// replayResult holds a replayed response and how it differs from the original
+type replayResult struct {
+	Status       int                 `json:"status"`
+	Headers      map[string][]string `json:"headers"`
+	Body         string              `json:"body"`
+	BodyEncoding string              `json:"body_encoding,omitempty"`
+	DurationMs   float64             `json:"duration_ms"`
+	Diff         string              `json:"diff"`
+}
+
+func (s *Store) handleReplay(w http.ResponseWriter, r *http.Request) {
+	ex, ok := s.Get(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "request not found"})
+		return
+	}
+
+	// Requiring JSON forces a CORS preflight, so other sites can't trigger
+	// replays. DNS rebinding gets past that, which localOnly stops
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "Content-Type must be application/json"})
+		return
+	}
+
+	var body replayRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid replay request: " + err.Error()})
+			return
+		}
+	}
+
+	edits := replay.Edits{Headers: body.Headers, Target: body.Target}
+	if body.Body != nil {
+		data := []byte(*body.Body)
+		if body.BodyEncoding == "base64" {
+			decoded, err := base64.StdEncoding.DecodeString(*body.Body)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid base64 body"})
+				return
+			}
+			data = decoded
+		}
+		edits.Body = &data
+	}
+
+	rec := ex.Record()
+	resp, err := replay.Send(s.ReplayClient, rec, edits)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("🔁 Replayed %s %s: %d", ex.Method, ex.Path, resp.Status)
+
+	result := replayResult{
+		Status:     resp.Status,
+		Headers:    resp.Headers,
+		DurationMs: float64(resp.Duration.Microseconds()) / 1000,
+		Diff:       replay.Diff(rec.Response, resp),
+	}
+	result.Body, result.BodyEncoding = encodeBody(resp.Body)
+	writeJSON(w, http.StatusOK, result)
+}
+
+func durationMs(ex Exchange) float64 {
+	duration := ex.Duration
+	if !ex.Done {
+		duration = time.Since(ex.Started)
+	}
+	return float64(duration.Microseconds()) / 1000
+}
+
+func encodeBody(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("❌ Error writing inspector response: %v", err)
+	}
+}
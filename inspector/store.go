@@ -0,0 +1,285 @@
+package inspector
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/panngo/devpipe-cli/replay"
+)
+
+// DefaultCapacity is how many exchanges the inspector keeps in memory
+const DefaultCapacity = 500
+
+// BodyLimit is the most body bytes kept per request or response
+const BodyLimit = 1 << 20
+
+// Exchange is one proxied request and the response it got
+type Exchange struct {
+	ID                string              `json:"id"`
+	Tunnel            string              `json:"tunnel,omitempty"`
+	Method            string              `json:"method"`
+	Path              string              `json:"path"`
+	Target            string              `json:"target,omitempty"`
+	TargetPath        string              `json:"target_path,omitempty"`
+	Webhook           string              `json:"webhook,omitempty"`
+	RequestHeaders    map[string][]string `json:"request_headers"`
+	RequestBody       []byte              `json:"-"`
+	RequestTruncated  bool                `json:"request_truncated"`
+	RequestSize       int64               `json:"request_size"`
+	Status            int                 `json:"status"`
+	ResponseHeaders   map[string][]string `json:"response_headers"`
+	ResponseBody      []byte              `json:"-"`
+	ResponseTruncated bool                `json:"response_truncated"`
+	ResponseSize      int64               `json:"response_size"`
+	Streamed          bool                `json:"streamed"`
+	Error             string              `json:"error,omitempty"`
+	Started           time.Time           `json:"started"`
+	Duration          time.Duration       `json:"duration"`
+	Done              bool                `json:"done"`
+}
+
+// Filter selects exchanges for listing. Empty fields match everything
+type Filter struct {
+	Method string
+	// Status is an exact code ("404") or a class ("4xx")
+	Status string
+	// Search matches a substring of the path
+	Search string
+}
+
+// Record converts an exchange into a replayable record, keeping the path the
+// upstream actually received
+func (ex Exchange) Record() replay.Record {
+	path := ex.Path
+	if ex.TargetPath != "" {
+		path = ex.TargetPath
+	}
+	return replay.Record{
+		ID:            ex.ID,
+		Time:          ex.Started,
+		Target:        ex.Target,
+		Method:        ex.Method,
+		Path:          path,
+		Headers:       ex.RequestHeaders,
+		Body:          ex.RequestBody,
+		BodyTruncated: ex.RequestTruncated,
+		Response: replay.Response{
+			Status:   ex.Status,
+			Headers:  ex.ResponseHeaders,
+			Body:     ex.ResponseBody,
+			Duration: ex.Duration,
+		},
+	}
+}
+
+// Store keeps the most recent exchanges in a fixed-size ring buffer
+type Store struct {
+	mu       sync.Mutex
+	ring     []*Exchange
+	next     int
+	byID     map[string]*Exchange
+	capacity int
+	// ReplayClient sends requests replayed from the UI
+	ReplayClient *http.Client
+	loads        []func() Load
+}
+
+// Load is how busy a tunnel is, for the stats of the UI
+type Load struct {
+	Tunnel        string `json:"tunnel,omitempty"`
+	Active        int64  `json:"active"`
+	Queued        int64  `json:"queued"`
+	Rejected      int64  `json:"rejected"`
+	MaxConcurrent int    `json:"max_concurrent"`
+	MaxQueue      int    `json:"max_queue"`
+}
+
+// AddLoad registers a tunnel whose load is reported by the stats
+func (s *Store) AddLoad(load func() Load) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loads = append(s.loads, load)
+}
+
+// Loads returns the current load of every registered tunnel
+func (s *Store) Loads() []Load {
+	s.mu.Lock()
+	loads := append([]func() Load(nil), s.loads...)
+	s.mu.Unlock()
+
+	out := make([]Load, 0, len(loads))
+	for _, load := range loads {
+		out = append(out, load())
+	}
+	return out
+}
+
+func NewStore(capacity int) *Store {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &Store{
+		ring:         make([]*Exchange, 0, capacity),
+		byID:         make(map[string]*Exchange, capacity),
+		capacity:     capacity,
+		ReplayClient: replay.DefaultClient,
+	}
+}
+
+// Begin records a new request, evicting the oldest exchange when full.
+// tunnel names the tunnel it came through when several are running
+func (s *Store) Begin(id, tunnel, method, path string, headers map[string][]string) {
+	ex := &Exchange{
+		ID:             id,
+		Tunnel:         tunnel,
+		Method:         method,
+		Path:           path,
+		RequestHeaders: cloneHeaders(headers),
+		Started:        time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.ring) < s.capacity {
+		s.ring = append(s.ring, ex)
+	} else {
+		if old := s.ring[s.next]; old != nil {
+			delete(s.byID, old.ID)
+		}
+		s.ring[s.next] = ex
+	}
+	s.next = (s.next + 1) % s.capacity
+	s.byID[id] = ex
+}
+
+// SetTarget records the upstream base URL a request was sent to, and the
+// path it was sent with when a route rewrote it
+func (s *Store) SetTarget(id, target, path string) {
+	s.update(id, func(ex *Exchange) {
+		ex.Target = target
+		if path != ex.Path {
+			ex.TargetPath = path
+		}
+	})
+}
+
+// SetWebhook records the result of a webhook signature check, e.g. "stripe: verified"
+func (s *Store) SetWebhook(id, result string) {
+	s.update(id, func(ex *Exchange) {
+		ex.Webhook = result
+	})
+}
+
+// AppendRequestBody adds bytes to a request body, up to BodyLimit
+func (s *Store) AppendRequestBody(id string, data []byte) {
+	s.update(id, func(ex *Exchange) {
+		ex.RequestSize += int64(len(data))
+		ex.RequestBody, ex.RequestTruncated = appendLimited(ex.RequestBody, data, ex.RequestTruncated)
+	})
+}
+
+// SetResponse records the response status and headers
+func (s *Store) SetResponse(id string, status int, headers map[string][]string, streamed bool) {
+	s.update(id, func(ex *Exchange) {
+		ex.Status = status
+		ex.ResponseHeaders = cloneHeaders(headers)
+		ex.Streamed = streamed
+	})
+}
+
+// AppendResponseBody adds bytes to a response body, up to BodyLimit
+func (s *Store) AppendResponseBody(id string, data []byte) {
+	s.update(id, func(ex *Exchange) {
+		ex.ResponseSize += int64(len(data))
+		ex.ResponseBody, ex.ResponseTruncated = appendLimited(ex.ResponseBody, data, ex.ResponseTruncated)
+	})
+}
+
+// Finish marks an exchange complete, with an optional error
+func (s *Store) Finish(id, errMsg string) {
+	s.update(id, func(ex *Exchange) {
+		if ex.Done {
+			return
+		}
+		ex.Done = true
+		ex.Duration = time.Since(ex.Started)
+		ex.Error = errMsg
+	})
+}
+
+// Get returns a copy of an exchange
+func (s *Store) Get(id string) (Exchange, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ex, ok := s.byID[id]
+	if !ok {
+		return Exchange{}, false
+	}
+	return *ex, true
+}
+
+// List returns copies of the matching exchanges, newest first
+func (s *Store) List(filter Filter) []Exchange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]Exchange, 0, len(s.ring))
+	for i := 1; i <= len(s.ring); i++ {
+		ex := s.ring[(s.next-i+len(s.ring))%len(s.ring)]
+		if filter.matches(ex) {
+			result = append(result, *ex)
+		}
+	}
+	return result
+}
+
+func (s *Store) update(id string, fn func(ex *Exchange)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ex, ok := s.byID[id]; ok {
+		fn(ex)
+	}
+}
+
+func (f Filter) matches(ex *Exchange) bool {
+	if f.Method != "" && !strings.EqualFold(f.Method, ex.Method) {
+		return false
+	}
+	if f.Search != "" && !strings.Contains(strings.ToLower(ex.Path), strings.ToLower(f.Search)) {
+		return false
+	}
+	if f.Status != "" {
+		status := strconv.Itoa(ex.Status)
+		if pattern := strings.ToLower(f.Status); strings.HasSuffix(pattern, "xx") {
+			return len(pattern) == 3 && strings.HasPrefix(status, pattern[:1])
+		}
+		return status == f.Status
+	}
+	return true
+}
+
+// appendLimited appends data without growing past BodyLimit
+func appendLimited(body, data []byte, truncated bool) ([]byte, bool) {
+	if truncated {
+		return body, true
+	}
+	room := BodyLimit - len(body)
+	if len(data) > room {
+		return append(body, data[:room]...), true
+	}
+	return append(body, data...), false
+}
+
+func cloneHeaders(headers map[string][]string) map[string][]string {
+	clone := make(map[string][]string, len(headers))
+	for k, v := range headers {
+		clone[k] = append([]string(nil), v...)
+	}
+	return clone
+}
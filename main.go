@@ -2,19 +2,51 @@
 package main
 
 import (
+	"log"
+	"os"
+
 	"github.com/panngo/devpipe-cli/client"
 	"github.com/panngo/devpipe-cli/ui"
 	"github.com/panngo/devpipe-cli/ws"
 )
 
 func main() {
-	port := client.ParseFlags()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			if err := client.RunReplay(os.Args[2:]); err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			return
+		case "start":
+			if err := client.RunStart(os.Args[2:]); err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			return
+		case "export":
+			if err := client.RunExport(os.Args[2:]); err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			return
+		}
+	}
+
+	opts := client.ParseFlags()
 
-	conn, tunnelID := ws.ConnectAndRegister("wss://devpipe.cloud/ws", port)
-	// conn, tunnelID := ws.ConnectAndRegister("ws://localhost:3000/ws", port)
+	conn, tunnelID := ws.ConnectAndRegister(opts.ServerURL, opts.Port, opts.Profile)
 	defer conn.Close()
 
-	ui.PrintBanner(port, tunnelID)
+	ui.PrintBanner(opts.Upstream.String(), ui.TunnelURL(opts.ServerURL, tunnelID))
 	ui.PrintSecureReconnectionInfo(conn.GetUUID())
-	client.ListenAndServe(conn, port)
+	if opts.InspectAddr != "" {
+		// Bind before printing, so the banner shows the port actually in use
+		if ln, err := opts.Inspector.Listen(opts.InspectAddr); err != nil {
+			log.Printf("⚠️  Inspector disabled: %v", err)
+		} else {
+			go opts.Inspector.Serve(ln)
+			ui.PrintInspectorInfo(ln.Addr().String())
+		}
+	}
+	client.ListenAndServe(client.NotifyShutdown(), conn, opts)
+	opts.Close()
 }
\ No newline at end of file
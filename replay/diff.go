@@ -0,0 +1,133 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDiffLines bounds the line diff; larger bodies are only compared by size
+const maxDiffLines = 2000
+
+// Headers that change on every response and would only add noise
+var volatileHeaders = map[string]bool{
+	"Date": true,
+}
+
+// Diff describes how a replayed response differs from the original, one
+// change per line prefixed with "-" (original) or "+" (replay)
+func Diff(old, new Response) string {
+	var out strings.Builder
+
+	if old.Status != new.Status {
+		fmt.Fprintf(&out, "Status:\n- %d\n+ %d\n", old.Status, new.Status)
+	}
+
+	if headers := diffHeaders(old.Headers, new.Headers); headers != "" {
+		out.WriteString("Headers:\n")
+		out.WriteString(headers)
+	}
+
+	if body := diffBodies(old.Body, new.Body); body != "" {
+		out.WriteString("Body:\n")
+		out.WriteString(body)
+	}
+
+	if out.Len() == 0 {
+		return "No differences\n"
+	}
+	return out.String()
+}
+
+func diffHeaders(old, new map[string][]string) string {
+	keys := make(map[string]bool)
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range new {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		if !volatileHeaders[k] {
+			sorted = append(sorted, k)
+		}
+	}
+	sort.Strings(sorted)
+
+	var out strings.Builder
+	for _, k := range sorted {
+		oldValues, newValues := old[k], new[k]
+		if strings.Join(oldValues, "\n") == strings.Join(newValues, "\n") {
+			continue
+		}
+		for _, v := range oldValues {
+			fmt.Fprintf(&out, "- %s: %s\n", k, v)
+		}
+		for _, v := range newValues {
+			fmt.Fprintf(&out, "+ %s: %s\n", k, v)
+		}
+	}
+	return out.String()
+}
+
+func diffBodies(old, new []byte) string {
+	if bytes.Equal(old, new) {
+		return ""
+	}
+	if !utf8.Valid(old) || !utf8.Valid(new) {
+		return fmt.Sprintf("- <binary, %d bytes>\n+ <binary, %d bytes>\n", len(old), len(new))
+	}
+
+	oldLines := strings.Split(string(old), "\n")
+	newLines := strings.Split(string(new), "\n")
+	if len(oldLines) > maxDiffLines || len(newLines) > maxDiffLines {
+		return fmt.Sprintf("- <%d bytes>\n+ <%d bytes>\n", len(old), len(new))
+	}
+	return diffLines(oldLines, newLines)
+}
+
+// diffLines prints the changed lines of two texts using their longest common subsequence
+func diffLines(a, b []string) string {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			fmt.Fprintf(&out, "- %s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&out, "+ %s\n", b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		fmt.Fprintf(&out, "- %s\n", a[i])
+	}
+	for ; j < len(b); j++ {
+		fmt.Fprintf(&out, "+ %s\n", b[j])
+	}
+	return out.String()
+}
@@ -0,0 +1,115 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Response is a captured or replayed response
+type Response struct {
+	Status   int                 `json:"status"`
+	Headers  map[string][]string `json:"headers"`
+	Body     []byte              `json:"body"`
+	Duration time.Duration       `json:"duration"`
+}
+
+// Record is a proxied request and the response the local app gave it
+type Record struct {
+	ID            string              `json:"id"`
+	Time          time.Time           `json:"time"`
+	Target        string              `json:"target"`
+	Method        string              `json:"method"`
+	Path          string              `json:"path"`
+	Headers       map[string][]string `json:"headers"`
+	Body          []byte              `json:"body"`
+	BodyTruncated bool                `json:"body_truncated,omitempty"`
+	Response      Response            `json:"response"`
+}
+
+// Edits changes a request before it is replayed
+type Edits struct {
+	// Headers replaces header values; an empty value removes the header
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body replaces the request body when set
+	Body *[]byte `json:"-"`
+	// Target overrides where the request is sent, e.g. http://localhost:4000
+	Target string `json:"target,omitempty"`
+}
+
+// DefaultClient sends replays over the default transport
+var DefaultClient = NewClient(nil)
+
+// NewClient returns a client that sends replays without following redirects,
+// so the replayed response can be compared with what the browser originally got.
+// A nil transport uses http.DefaultTransport
+func NewClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{
+		Transport: transport,
+		Timeout:   60 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Send re-sends a recorded request with the given edits and returns the new response
+func Send(client *http.Client, rec Record, edits Edits) (Response, error) {
+	target := rec.Target
+	if edits.Target != "" {
+		target = edits.Target
+	}
+	if target == "" {
+		return Response{}, fmt.Errorf("request %s has no target to replay against", rec.ID)
+	}
+
+	body := rec.Body
+	if edits.Body != nil {
+		body = *edits.Body
+	} else if rec.BodyTruncated {
+		return Response{}, fmt.Errorf("request %s body was truncated when captured; supply a new body to replay it", rec.ID)
+	}
+
+	req, err := http.NewRequest(rec.Method, strings.TrimSuffix(target, "/")+rec.Path, bytes.NewReader(body))
+	if err != nil {
+		return Response{}, fmt.Errorf("failed to build request: %w", err)
+	}
+	for k, values := range rec.Headers {
+		switch strings.ToLower(k) {
+		case "host", "connection", "transfer-encoding", "content-length":
+			continue
+		}
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+	for k, v := range edits.Headers {
+		if v == "" {
+			req.Header.Del(k)
+		} else {
+			req.Header.Set(k, v)
+		}
+	}
+
+	started := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return Response{}, fmt.Errorf("replay failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Response{}, fmt.Errorf("failed to read replay response: %w", err)
+	}
+
+	return Response{
+		Status:   resp.StatusCode,
+		Headers:  resp.Header,
+		Body:     respBody,
+		Duration: time.Since(started),
+	}, nil
+}
@@ -0,0 +1,120 @@
+package replay
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// DefaultMaxRecords is how many requests are kept on disk for replay
+const DefaultMaxRecords = 500
+
+// pruneEvery is how many saves happen between prunes of old records
+const pruneEvery = 50
+
+var safeID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Store keeps recorded requests on disk, one JSON file per request
+type Store struct {
+	dir        string
+	maxRecords int
+	mu         sync.Mutex
+	saves      int
+}
+
+func NewStore(dir string, maxRecords int) *Store {
+	if maxRecords <= 0 {
+		maxRecords = DefaultMaxRecords
+	}
+	return &Store{dir: dir, maxRecords: maxRecords}
+}
+
+// Save writes a record, pruning the oldest ones now and then
+func (s *Store) Save(rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %w", err)
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+	if err := os.WriteFile(s.path(rec.ID), data, 0600); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+
+	s.mu.Lock()
+	s.saves++
+	prune := s.saves%pruneEvery == 0
+	s.mu.Unlock()
+
+	if prune {
+		return s.prune()
+	}
+	return nil
+}
+
+// Load reads a record by request ID
+func (s *Store) Load(id string) (*Record, error) {
+	data, err := os.ReadFile(s.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no recorded request with ID %s", id)
+		}
+		return nil, fmt.Errorf("failed to read record: %w", err)
+	}
+
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal record: %w", err)
+	}
+	return &rec, nil
+}
+
+// prune removes the oldest records beyond maxRecords
+func (s *Store) prune() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list history: %w", err)
+	}
+	if len(entries) <= s.maxRecords {
+		return nil
+	}
+
+	type file struct {
+		name    string
+		modTime int64
+	}
+	files := make([]file, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		files = append(files, file{name: entry.Name(), modTime: info.ModTime().UnixNano()})
+	}
+	if len(files) <= s.maxRecords {
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime < files[j].modTime })
+
+	for _, f := range files[:len(files)-s.maxRecords] {
+		os.Remove(filepath.Join(s.dir, f.name))
+	}
+	return nil
+}
+
+// path maps a request ID to its file, hex-encoding IDs that aren't filename-safe
+func (s *Store) path(id string) string {
+	if !safeID.MatchString(id) {
+		id = "x" + hex.EncodeToString([]byte(id))
+	}
+	return filepath.Join(s.dir, id+".json")
+}
@@ -2,26 +2,70 @@ package ui
 
 import (
 	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
+	"github.com/panngo/devpipe-cli/config"
 )
 
-func PrintBanner(port, tunnelID string) {
+// TunnelURL is the public URL of a tunnel: its ID under the domain of the
+// server it registered with, e.g. wss://devpipe.cloud/ws serves
+// https://<tunnel>.devpipe.cloud
+func TunnelURL(serverURL, tunnelID string) string {
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Hostname() == "" {
+		u, _ = url.Parse(config.DefaultServerURL)
+	}
+	return "https://" + tunnelID + "." + u.Hostname()
+}
+
+func PrintBanner(upstream, tunnelURL string) {
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	printBannerHeader()
+	fmt.Printf("%-15s %s\n", "Forwarding", fmt.Sprintf("%s -> %s", yellow(tunnelURL), upstream))
+	printBannerFooter()
+	fmt.Printf("%-6s %-20s %-6s\n", "METHOD", "PATH", "STATUS")
+}
+
+// Tunnel is one line of the banner printed by `devpipe start`
+type Tunnel struct {
+	Name     string
+	URL      string
+	Upstream string
+}
+
+// PrintTunnelsBanner lists every tunnel started from a tunnel file
+func PrintTunnelsBanner(tunnels []Tunnel) {
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	printBannerHeader()
+	for _, tunnel := range tunnels {
+		fmt.Printf("%-15s %s\n", tunnel.Name, fmt.Sprintf("%s -> %s", yellow(tunnel.URL), tunnel.Upstream))
+	}
+	printBannerFooter()
+	fmt.Printf("%-12s %-6s %-20s %-6s\n", "TUNNEL", "METHOD", "PATH", "STATUS")
+}
+
+func printBannerHeader() {
 	clearConsole()
 	cyan := color.New(color.FgCyan).SprintFunc()
 	green := color.New(color.FgGreen).SprintFunc()
-	yellow := color.New(color.FgYellow).SprintFunc()
-	blue := color.New(color.FgBlue).SprintFunc()
 
 	fmt.Println(cyan("@devpipe"))
 	fmt.Println()
 	fmt.Printf("%-15s %s\n", "Tunnel Status", green("online"))
 	fmt.Printf("%-15s %s\n", "Version", "custom-devpipe")
-	fmt.Printf("%-15s %s\n", "Forwarding", fmt.Sprintf("%s -> localhost:%s", yellow("https://"+tunnelID+".devpipe.cloud"), port))
+}
+
+func printBannerFooter() {
+	blue := color.New(color.FgBlue).SprintFunc()
+
 	fmt.Printf("%-15s %s\n", "Security", blue("🔐 Secure Reconnection Enabled"))
 	fmt.Println()
 	fmt.Println("HTTP Requests")
-	fmt.Printf("%-6s %-20s %-6s\n", "METHOD", "PATH", "STATUS")
 }
 
 func PrintSecureReconnectionInfo(uuid string) {
@@ -32,6 +76,47 @@ func PrintSecureReconnectionInfo(uuid string) {
 	}
 }
 
+func PrintInspectorInfo(addr string) {
+	blue := color.New(color.FgBlue).SprintFunc()
+	fmt.Printf("%-15s %s\n", "Web Interface", blue("http://"+addr))
+}
+
+// PrintDiff prints a replay diff, coloring removed and added lines
+func PrintDiff(diff string) {
+	red := color.New(color.FgRed).SprintFunc()
+	green := color.New(color.FgGreen).SprintFunc()
+
+	for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "- "):
+			fmt.Println(red(line))
+		case strings.HasPrefix(line, "+ "):
+			fmt.Println(green(line))
+		default:
+			fmt.Println(line)
+		}
+	}
+}
+
+// PrintReconnectCountdown keeps one status line with the time left before
+// the next reconnect attempt, redrawn in place
+func PrintReconnectCountdown(name string, attempt int, remaining time.Duration) {
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	if name != "" {
+		name += ": "
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	fmt.Printf("\r\033[K%s", yellow(fmt.Sprintf("🔄 %sReconnecting in %v (attempt %d)", name, remaining.Round(time.Second), attempt)))
+}
+
+// ClearStatus erases the status line
+func ClearStatus() {
+	fmt.Print("\r\033[K")
+}
+
 func clearConsole() {
 	fmt.Print("\033[H\033[2J")
 }
\ No newline at end of file
@@ -1,20 +1,65 @@
 package ws
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/panngo/devpipe-cli/config"
 )
 
+// Optional protocol features negotiated with the server at registration
+const (
+	// CapabilityStreaming allows responses to be sent as response_start/chunk/end frames
+	CapabilityStreaming = "stream"
+	// CapabilityRequestStreaming allows request bodies to arrive as request_chunk frames
+	CapabilityRequestStreaming = "request_stream"
+	// CapabilityWebSocket allows local WebSockets to be relayed with ws_* frames
+	CapabilityWebSocket = "websocket"
+	// CapabilityBase64Body allows bodies to be sent base64-encoded via body_encoding
+	CapabilityBase64Body = "base64_body"
+	// CapabilityForwardedFor means the edge appends the visitor address as the
+	// last X-Forwarded-For hop, so that hop can be trusted
+	CapabilityForwardedFor = "forwarded_for"
+)
+
+// ErrRejected means the server answered a registration with an error, such
+// as unknown or invalid reconnection credentials. Network failures aren't
+// rejections, so the saved credentials are kept for the next attempt
+var ErrRejected = errors.New("server error")
+
+// ErrNoTunnelConfig means there are no saved credentials to reconnect with
+var ErrNoTunnelConfig = errors.New("no valid tunnel configuration found for reconnection")
+
+// ProtocolVersion is the tunnel protocol this client speaks. Version 2 sends
+// headers as lists of values; servers that don't report a version speak 1
+const ProtocolVersion = 2
+
+// ClientVersion identifies this build to the server. Release builds set it with
+// -ldflags "-X github.com/panngo/devpipe-cli/ws.ClientVersion=<version>"
+var ClientVersion = "dev"
+
+// ClientCapabilities lists the protocol features this client supports
+var ClientCapabilities = []string{
+	CapabilityStreaming,
+	CapabilityRequestStreaming,
+	CapabilityWebSocket,
+	CapabilityBase64Body,
+	CapabilityForwardedFor,
+}
+
 type SafeConn struct {
 	*websocket.Conn
-	TunnelID    string
-	UUID        string
-	SecurityKey string
-	writeMutex  sync.Mutex
+	TunnelID     string
+	UUID         string
+	SecurityKey  string
+	Protocol     int
+	Capabilities map[string]bool
+	writeMutex   sync.Mutex
 }
 
 // WriteJSON thread-safe wrapper
@@ -24,16 +69,83 @@ func (s *SafeConn) WriteJSON(v interface{}) error {
 	return s.Conn.WriteJSON(v)
 }
 
-// RegistrationResponse represents the server response for registration
+// Registration is the message a client sends to register a tunnel. UUID and
+// Key are only set when resuming a saved tunnel
+type Registration struct {
+	Action        string   `json:"action"`
+	Port          string   `json:"port"`
+	UUID          string   `json:"uuid,omitempty"`
+	Key           string   `json:"key,omitempty"`
+	ClientVersion string   `json:"client_version"`
+	Protocol      int      `json:"protocol"`
+	Capabilities  []string `json:"capabilities"`
+}
+
+// RegistrationResponse represents the server response for registration.
+// Servers that predate the handshake omit Protocol and Capabilities, which
+// leaves every optional feature disabled
 type RegistrationResponse struct {
-	Tunnel      string `json:"tunnel"`
-	UUID        string `json:"uuid"`
-	SecurityKey string `json:"key"`
-	Error       string `json:"error,omitempty"`
+	Tunnel        string   `json:"tunnel"`
+	UUID          string   `json:"uuid"`
+	SecurityKey   string   `json:"key"`
+	ServerVersion string   `json:"server_version,omitempty"`
+	Protocol      int      `json:"protocol,omitempty"`
+	Capabilities  []string `json:"capabilities,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// newRegistration builds the register message, advertising our capabilities
+func newRegistration(port string) Registration {
+	return Registration{
+		Action:        "register",
+		Port:          port,
+		ClientVersion: ClientVersion,
+		Protocol:      ProtocolVersion,
+		Capabilities:  ClientCapabilities,
+	}
+}
+
+// applyRegistration stores the tunnel info and enabled capabilities from the server response
+func (s *SafeConn) applyRegistration(response RegistrationResponse) {
+	s.TunnelID = response.Tunnel
+	s.UUID = response.UUID
+	s.SecurityKey = response.SecurityKey
+	s.Protocol = response.Protocol
+	if s.Protocol == 0 {
+		s.Protocol = 1
+	} else if s.Protocol > ProtocolVersion {
+		s.Protocol = ProtocolVersion
+	}
+	s.Capabilities = make(map[string]bool, len(response.Capabilities))
+	for _, capability := range response.Capabilities {
+		// Ignore anything the server enables that we never offered
+		for _, offered := range ClientCapabilities {
+			if capability == offered {
+				s.Capabilities[capability] = true
+			}
+		}
+	}
+
+	enabled := make([]string, 0, len(s.Capabilities))
+	for _, capability := range ClientCapabilities {
+		if s.Capabilities[capability] {
+			enabled = append(enabled, capability)
+		}
+	}
+	if len(enabled) == 0 {
+		enabled = append(enabled, "none")
+	}
+	serverVersion := response.ServerVersion
+	if serverVersion == "" {
+		serverVersion = "unknown"
+	}
+	log.Printf("🤝 Server %s, protocol v%d, features: %s", serverVersion, s.Protocol, strings.Join(enabled, ", "))
 }
 
-func ConnectAndRegister(serverUrl, port string) (*SafeConn, string) {
-	configManager := config.NewConfigManager()
+// ConnectAndRegister registers a tunnel, resuming the credentials saved for
+// the profile (or in tunnel.json, when profile is empty) if any
+func ConnectAndRegister(serverUrl, port, profile string) (*SafeConn, string) {
+	configManager := config.NewConfigManager().ForProfile(profile)
 	
 	// Try to load existing tunnel configuration
 	existingConfig, err := configManager.LoadTunnelConfig()
@@ -50,16 +162,13 @@ func ConnectAndRegister(serverUrl, port string) (*SafeConn, string) {
 	safeConn := &SafeConn{Conn: conn}
 	
 	// Prepare registration message
-	registration := map[string]string{
-		"action": "register",
-		"port":   port,
-	}
+	registration := newRegistration(port)
 	
 	// If we have existing config with UUID and security key, try secure reconnection
 	if existingConfig != nil && existingConfig.UUID != "" && existingConfig.SecurityKey != "" {
 		log.Printf("🔐 Attempting secure reconnection with UUID: %s", existingConfig.UUID)
-		registration["uuid"] = existingConfig.UUID
-		registration["key"] = existingConfig.SecurityKey
+		registration.UUID = existingConfig.UUID
+		registration.Key = existingConfig.SecurityKey
 		safeConn.UUID = existingConfig.UUID
 		safeConn.SecurityKey = existingConfig.SecurityKey
 	} else {
@@ -81,9 +190,7 @@ func ConnectAndRegister(serverUrl, port string) (*SafeConn, string) {
 	}
 	
 	// Update connection with new tunnel info
-	safeConn.TunnelID = response.Tunnel
-	safeConn.UUID = response.UUID
-	safeConn.SecurityKey = response.SecurityKey
+	safeConn.applyRegistration(response)
 	
 	// Save the new configuration
 	newConfig := config.TunnelConfig{
@@ -102,8 +209,8 @@ func ConnectAndRegister(serverUrl, port string) (*SafeConn, string) {
 	return safeConn, response.Tunnel
 }
 
-func ConnectAndRegisterWithRetry(serverUrl, port string) (*SafeConn, string, error) {
-	configManager := config.NewConfigManager()
+func ConnectAndRegisterWithRetry(serverUrl, port, profile string) (*SafeConn, string, error) {
+	configManager := config.NewConfigManager().ForProfile(profile)
 	
 	// Try to load existing tunnel configuration
 	existingConfig, err := configManager.LoadTunnelConfig()
@@ -120,16 +227,13 @@ func ConnectAndRegisterWithRetry(serverUrl, port string) (*SafeConn, string, err
 	safeConn := &SafeConn{Conn: conn}
 	
 	// Prepare registration message
-	registration := map[string]string{
-		"action": "register",
-		"port":   port,
-	}
+	registration := newRegistration(port)
 	
 	// If we have existing config with UUID and security key, try secure reconnection
 	if existingConfig != nil && existingConfig.UUID != "" && existingConfig.SecurityKey != "" {
 		log.Printf("🔐 Attempting secure reconnection with UUID: %s", existingConfig.UUID)
-		registration["uuid"] = existingConfig.UUID
-		registration["key"] = existingConfig.SecurityKey
+		registration.UUID = existingConfig.UUID
+		registration.Key = existingConfig.SecurityKey
 		safeConn.UUID = existingConfig.UUID
 		safeConn.SecurityKey = existingConfig.SecurityKey
 	} else {
@@ -150,13 +254,11 @@ func ConnectAndRegisterWithRetry(serverUrl, port string) (*SafeConn, string, err
 	// Check for server error
 	if response.Error != "" {
 		conn.Close()
-		return nil, "", fmt.Errorf("server error: %s", response.Error)
+		return nil, "", fmt.Errorf("%w: %s", ErrRejected, response.Error)
 	}
 	
 	// Update connection with new tunnel info
-	safeConn.TunnelID = response.Tunnel
-	safeConn.UUID = response.UUID
-	safeConn.SecurityKey = response.SecurityKey
+	safeConn.applyRegistration(response)
 	
 	// Save the new configuration
 	newConfig := config.TunnelConfig{
@@ -176,17 +278,17 @@ func ConnectAndRegisterWithRetry(serverUrl, port string) (*SafeConn, string, err
 }
 
 // ConnectAndReconnect attempts to reconnect with a specific tunnel ID using secure reconnection
-func ConnectAndReconnect(serverUrl, port, tunnelID string) (*SafeConn, string, error) {
-	configManager := config.NewConfigManager()
+func ConnectAndReconnect(serverUrl, port, profile, tunnelID string) (*SafeConn, string, error) {
+	configManager := config.NewConfigManager().ForProfile(profile)
 	
 	// Load existing tunnel configuration
 	existingConfig, err := configManager.LoadTunnelConfig()
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to load tunnel config: %w", err)
+		return nil, "", fmt.Errorf("%w: %v", ErrNoTunnelConfig, err)
 	}
 	
 	if existingConfig == nil || existingConfig.UUID == "" || existingConfig.SecurityKey == "" {
-		return nil, "", fmt.Errorf("no valid tunnel configuration found for reconnection")
+		return nil, "", ErrNoTunnelConfig
 	}
 	
 	dialer := websocket.Dialer{}
@@ -199,12 +301,9 @@ func ConnectAndReconnect(serverUrl, port, tunnelID string) (*SafeConn, string, e
 	
 	// Attempt secure reconnection with UUID and security key
 	log.Printf("🔐 Attempting secure reconnection with UUID: %s", existingConfig.UUID)
-	registration := map[string]string{
-		"action": "register",
-		"port":   port,
-		"uuid":   existingConfig.UUID,
-		"key":    existingConfig.SecurityKey,
-	}
+	registration := newRegistration(port)
+	registration.UUID = existingConfig.UUID
+	registration.Key = existingConfig.SecurityKey
 	
 	if err := safeConn.WriteJSON(registration); err != nil {
 		conn.Close()
@@ -220,7 +319,7 @@ func ConnectAndReconnect(serverUrl, port, tunnelID string) (*SafeConn, string, e
 	// Check for server error
 	if response.Error != "" {
 		conn.Close()
-		return nil, "", fmt.Errorf("server error: %s", response.Error)
+		return nil, "", fmt.Errorf("%w: %s", ErrRejected, response.Error)
 	}
 	
 	// Verify we got the same tunnel ID back
@@ -229,13 +328,30 @@ func ConnectAndReconnect(serverUrl, port, tunnelID string) (*SafeConn, string, e
 	}
 	
 	// Update connection with tunnel info
-	safeConn.TunnelID = response.Tunnel
-	safeConn.UUID = response.UUID
-	safeConn.SecurityKey = response.SecurityKey
+	safeConn.applyRegistration(response)
 	
 	return safeConn, response.Tunnel, nil
 }
 
+// Unregistration tells the server a tunnel is going away on purpose, so it
+// can release it right away instead of waiting for the connection to time out
+type Unregistration struct {
+	Action string `json:"action"`
+	Tunnel string `json:"tunnel"`
+}
+
+// Unregister sends the unregister action for the tunnel of the connection
+func (s *SafeConn) Unregister() error {
+	return s.WriteJSON(Unregistration{Action: "unregister", Tunnel: s.TunnelID})
+}
+
+// CloseGracefully sends a normal close frame, so the server sees a clean
+// shutdown rather than a dropped connection
+func (s *SafeConn) CloseGracefully(reason string) error {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
+	return s.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+}
+
 // GetTunnelID returns the tunnel ID of the connection
 func (s *SafeConn) GetTunnelID() string {
 	return s.TunnelID
@@ -246,6 +362,11 @@ func (s *SafeConn) GetUUID() string {
 	return s.UUID
 }
 
+// HasCapability reports whether the server enabled the given protocol feature
+func (s *SafeConn) HasCapability(name string) bool {
+	return s.Capabilities[name]
+}
+
 // GetSecurityKey returns the security key of the connection
 func (s *SafeConn) GetSecurityKey() string {
 	return s.SecurityKey